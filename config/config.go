@@ -7,12 +7,57 @@ import (
 	"bufio"
 	"fmt"
 	"log"
+	"math"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 )
 
+const (
+	// MaxInflightStatic enforces max_inflight as a fixed limit.
+	MaxInflightStatic = "static"
+
+	// MaxInflightAdaptive adjusts the limit based upon latency and errors.
+	MaxInflightAdaptive = "adaptive"
+)
+
+const (
+	// PriorityHigh requests may use the slots reserved by PriorityReservedShare.
+	PriorityHigh = "high"
+
+	// PriorityLow requests are shed once only the reserved slots are left.
+	PriorityLow = "low"
+
+	// AccessLogText writes one human readable line per request.
+	AccessLogText = "text"
+
+	// AccessLogJSON writes one JSON object per request.
+	AccessLogJSON = "json"
+
+	// AccessLogLogfmt writes one line of key=value pairs per request.
+	AccessLogLogfmt = "logfmt"
+
+	// LogFormatText passes through lines from the function as they are.
+	LogFormatText = "text"
+
+	// LogFormatJSON writes each line from the function as a JSON object.
+	LogFormatJSON = "json"
+
+	// LogOverflowSplit writes lines longer than log_buffer_size in parts.
+	LogOverflowSplit = "split"
+
+	// LogOverflowTruncate cuts lines longer than log_buffer_size short.
+	LogOverflowTruncate = "truncate"
+)
+
+// metricPrefix matches a valid Prometheus metric name.
+var metricPrefix = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
 // WatchdogConfig configuration for a watchdog.
 type WatchdogConfig struct {
 	TCPPort             int
@@ -37,12 +82,201 @@ type WatchdogConfig struct {
 	// MetricsPort TCP port on which to serve HTTP Prometheus metrics
 	MetricsPort int
 
+	// MetricsReadTimeout and MetricsWriteTimeout are the timeouts of the
+	// metrics server.
+	MetricsReadTimeout  time.Duration
+	MetricsWriteTimeout time.Duration
+
+	// MetricsBuckets are the upper bounds in seconds of the buckets of
+	// the request duration histograms.
+	MetricsBuckets []float64
+
+	// MetricsFunctionLabels adds the function's name and namespace as
+	// constant labels to the watchdog's own metrics.
+	MetricsFunctionLabels bool
+
+	// MetricsNativeHistograms records native histograms alongside the
+	// classic buckets.
+	MetricsNativeHistograms bool
+
+	// ProcessMaxRSS restarts the function process in http mode when the
+	// resident memory of its process tree is over this many bytes, it is
+	// checked on each ProcessCheckInterval.
+	ProcessMaxRSS        int64
+	ProcessCheckInterval time.Duration
+
+	// IdleTimeout drains and stops the watchdog once no requests have
+	// been made to the function for this long, 0 disables it.
+	IdleTimeout time.Duration
+
+	// IdleExitCode is the exit code after an idle shutdown.
+	IdleExitCode int
+
+	// IdleHook is a command run after an idle shutdown, before exiting.
+	IdleHook string
+
+	// StatsDAddress is a localhost UDP address, i.e. 127.0.0.1:8125, on
+	// which to receive custom metrics from the function over StatsD.
+	// It is passed to the function as STATSD_ADDR.
+	StatsDAddress string
+
+	// StatsDPrefix is added to the name of each custom metric.
+	StatsDPrefix string
+
+	// StatsDMaxSeries is the most series of custom metrics kept.
+	StatsDMaxSeries int
+
+	// MetricsExemplars attaches the trace ID, or the call ID, of a request
+	// to the request metrics as an OpenMetrics exemplar.
+	MetricsExemplars bool
+
+	// MetricsRoutes are route templates such as /users/{id}, which add
+	// a path label to the HTTP metrics. A path which matches none of
+	// them is recorded as "other".
+	MetricsRoutes []string
+
 	// MaxInflight limits the number of simultaneous
 	// requests that the watchdog allows concurrently.
 	// Any request which exceeds this limit will
 	// have an immediate response of 429.
 	MaxInflight int
 
+	// MaxInflightMode is "static" to enforce MaxInflight as a fixed limit,
+	// or "adaptive" to adjust the limit from the observed latency and
+	// error rate, starting at MaxInflight.
+	MaxInflightMode string
+
+	// AdaptiveMinInflight and AdaptiveMaxInflight bound the limit
+	// when MaxInflightMode is "adaptive".
+	AdaptiveMinInflight int
+	AdaptiveMaxInflight int
+
+	// AdaptiveWindow is how often the adaptive limit is recalculated.
+	AdaptiveWindow time.Duration
+
+	// AdaptiveLatencyTolerance is the multiple of the baseline latency
+	// above which the adaptive limit is reduced.
+	AdaptiveLatencyTolerance float64
+
+	// PriorityLanes reserves a share of the MaxInflight slots for
+	// high priority requests, and sheds low priority requests first.
+	PriorityLanes bool
+
+	// PriorityHeader is the request header which gives the priority
+	// class, either "high" or "low".
+	PriorityHeader string
+
+	// PriorityClaim is the JWT claim used for the priority class, in
+	// place of the header. Empty to use the header.
+	PriorityClaim string
+
+	// PriorityReservedShare is the fraction of MaxInflight reserved
+	// for high priority requests.
+	PriorityReservedShare float64
+
+	// PriorityDefault is the class for requests with no priority.
+	PriorityDefault string
+
+	// MemoryCgroupPath is the cgroup v2 directory to read
+	// memory.current and memory.max from.
+	MemoryCgroupPath string
+
+	// MemoryHighWatermark is the fraction of memory.max above which new
+	// requests are rejected, 0 to disable. Requests are accepted again
+	// once usage falls below MemoryLowWatermark.
+	MemoryHighWatermark float64
+	MemoryLowWatermark  float64
+
+	// MemoryCheckInterval is how often memory usage is read.
+	MemoryCheckInterval time.Duration
+
+	// LimitRules are concurrency and rate limits applied to
+	// matching requests, loaded from the limit_rules_file.
+	LimitRules []LimitRule
+
+	// HealthChecks are extra checks for /_/health and /_/ready, loaded
+	// from the health_checks_file.
+	HealthChecks []HealthCheck
+
+	// Warmup requests are sent to the function before the lock file is
+	// written, each is retried until it returns its status or the
+	// WarmupTimeout for all of them passes.
+	Warmup        []WarmupRequest
+	WarmupTimeout time.Duration
+
+	// AccessLogFormat is one of AccessLogText, AccessLogJSON
+	// or AccessLogLogfmt.
+	AccessLogFormat string
+
+	// AccessLogSkipUserAgents are User-Agent prefixes for which no access
+	// log is written, such as the kubelet's health checks.
+	AccessLogSkipUserAgents []string
+
+	// TraceOTLPEndpoint is the OTLP/HTTP endpoint that spans are exported
+	// to, tracing is disabled when empty.
+	TraceOTLPEndpoint string
+
+	// TraceSampleRatio is the fraction of new traces to sample, traces
+	// started upstream follow the sampling decision of the parent.
+	TraceSampleRatio float64
+
+	// MaxInflightQueue is the number of requests that can wait for
+	// a slot when MaxInflight is reached, before a 429 is returned.
+	MaxInflightQueue int
+
+	// MaxInflightQueueTimeout is the longest a request will wait
+	// in the queue before a 429 is returned.
+	MaxInflightQueueTimeout time.Duration
+
+	// LogFormat is LogFormatText or LogFormatJSON, for the lines
+	// read from the function's stdout and stderr.
+	LogFormat string
+
+	// LogOverflow is LogOverflowSplit or LogOverflowTruncate, for
+	// lines from the function longer than LogBufferSize.
+	LogOverflow string
+
+	// LogMultiline groups lines from the function into one record,
+	// such as the lines of a stack trace. A line continues the record
+	// when it matches LogMultilineContinue, or when LogMultilineStart
+	// is set and it does not match.
+	LogMultiline         bool
+	LogMultilineStart    *regexp.Regexp
+	LogMultilineContinue *regexp.Regexp
+
+	// LogMultilineTimeout is how long to wait for another line before
+	// a record is written, and LogMultilineMaxBytes the largest record.
+	LogMultilineTimeout  time.Duration
+	LogMultilineMaxBytes int
+
+	// LogRateLimit is the lines per second logged from each of the
+	// function's streams, with up to LogRateBurst at once, or 0 for no
+	// limit. LogRateSample keeps one in every n lines over the limit.
+	LogRateLimit  float64
+	LogRateBurst  int
+	LogRateSample int
+
+	// LogErrorPattern matches lines which are kept over the rate limit.
+	LogErrorPattern *regexp.Regexp
+
+	// LogRateSummaryInterval is how often the number of lines
+	// suppressed by the rate limit is logged.
+	LogRateSummaryInterval time.Duration
+
+	// LogSyslogAddress is a URL of a syslog server to send logs to as
+	// well as stdout and stderr, i.e. udp://127.0.0.1:514. LogSyslogTag
+	// is the app name, which defaults to the function's name.
+	LogSyslogAddress string
+	LogSyslogTag     string
+
+	// LogFile is a path to write logs to as well as stdout and stderr,
+	// which is rotated by size and age.
+	LogFile           string
+	LogFileMaxSize    int64
+	LogFileMaxAge     time.Duration
+	LogFileMaxBackups int
+	LogFileCompress   bool
+
 	// PrefixLogs adds a date time stamp and the stdio name to any
 	// logging from executing functions
 	PrefixLogs bool
@@ -54,6 +288,21 @@ type WatchdogConfig struct {
 	// the /_/ready endpoint with proxy the request to this path.
 	ReadyEndpoint string
 
+	// ReadyInterval probes the ReadyEndpoint in the background, so that
+	// /_/ready returns the last result. When 0, the ReadyEndpoint is
+	// probed for each request to /_/ready.
+	ReadyInterval time.Duration
+	ReadyTimeout  time.Duration
+
+	// ReadySuccessThreshold and ReadyFailureThreshold are the consecutive
+	// probes needed to become ready or not ready, as per Kubernetes.
+	ReadySuccessThreshold int
+	ReadyFailureThreshold int
+
+	// LockPath is the lock file written once the watchdog is ready, and
+	// removed when it starts draining, unless SuppressLock is set.
+	LockPath string
+
 	// JWTAuthentication enables JWT authentication for the watchdog
 	// using the OpenFaaS gateway as the issuer.
 	JWTAuthentication bool
@@ -65,14 +314,19 @@ type WatchdogConfig struct {
 	// local gateway running at `http://127.0.0.1:8000` instead of attempting to reach it via an in-cluster service
 	JWTAuthLocal bool
 
-	// LogCallId includes a prefix of the X-Call-Id in any log statements in
-	// HTTP mode.
+	// LogCallId appends the X-Call-Id to each line of the text access log.
 	LogCallId bool
 
 	// Handler is the HTTP handler to use in "inproc" mode
 	Handler http.HandlerFunc
 }
 
+// DefaultLockPath is the lock file in the temporary directory, i.e.
+// /tmp/.lock.
+func DefaultLockPath() string {
+	return filepath.Join(os.TempDir(), ".lock")
+}
+
 // Process returns a string for the process and a slice for the arguments from the FunctionProcess.
 func (w WatchdogConfig) Process() (string, []string) {
 	parts := strings.Split(w.FunctionProcess, " ")
@@ -88,6 +342,31 @@ func (w *WatchdogConfig) SetHandler(handler http.HandlerFunc) {
 	w.Handler = handler
 }
 
+// ValidateInproc returns an error when an option is set which only applies
+// to the output of a function process, since a handler in inproc mode
+// writes its own logs.
+func (w WatchdogConfig) ValidateInproc() error {
+	var options []string
+	if w.LogFormat == LogFormatJSON {
+		options = append(options, "log_format=json")
+	}
+	if w.LogOverflow == LogOverflowTruncate {
+		options = append(options, "log_overflow=truncate")
+	}
+	if w.LogMultiline {
+		options = append(options, "log_multiline")
+	}
+	if w.LogRateLimit > 0 {
+		options = append(options, "log_rate_limit")
+	}
+
+	if len(options) > 0 {
+		return fmt.Errorf(`%s can't be used with "mode=inproc", as there is no function process to read logs from`, strings.Join(options, ", "))
+	}
+
+	return nil
+}
+
 // New create config based upon environmental variables.
 func New(env []string) (WatchdogConfig, error) {
 	defaultTimeout := time.Second * 30
@@ -158,8 +437,13 @@ func New(env []string) (WatchdogConfig, error) {
 		}
 	}
 
+	port, err := getPort(envMap)
+	if err != nil {
+		return WatchdogConfig{}, err
+	}
+
 	c := WatchdogConfig{
-		TCPPort:             getInt(envMap, "port", 8080),
+		TCPPort:             port,
 		HTTPReadTimeout:     getDuration(envMap, "read_timeout", defaultTimeout),
 		HTTPWriteTimeout:    writeTimeout,
 		HealthcheckInterval: healthcheckInterval,
@@ -172,7 +456,7 @@ func New(env []string) (WatchdogConfig, error) {
 		SuppressLock:        getBool(envMap, "suppress_lock"),
 		UpstreamURL:         upstreamURL,
 		BufferHTTPBody:      getBools(envMap, "buffer_http", "http_buffer_req_body"),
-		MetricsPort:         8081,
+		MetricsPort:         getInt(envMap, "metrics_port", 8081),
 		MaxInflight:         getInt(envMap, "max_inflight", 0),
 		PrefixLogs:          prefixLogs,
 		LogBufferSize:       logBufferSize,
@@ -180,6 +464,294 @@ func New(env []string) (WatchdogConfig, error) {
 		LogCallId:           logCallId,
 	}
 
+	c.MaxInflightQueue = getInt(envMap, "max_inflight_queue", 0)
+	c.MaxInflightQueueTimeout = getDuration(envMap, "max_inflight_queue_timeout", writeTimeout)
+	if c.MaxInflightQueue > 0 && c.MaxInflightQueueTimeout <= 0 {
+		return c, fmt.Errorf("invalid max_inflight_queue_timeout value: %s, must be over 0s", c.MaxInflightQueueTimeout)
+	}
+
+	c.MaxInflightMode = MaxInflightStatic
+	if val, exists := envMap["max_inflight_mode"]; exists {
+		if val != MaxInflightStatic && val != MaxInflightAdaptive {
+			return c, fmt.Errorf("invalid max_inflight_mode value: %s, use %q or %q", val, MaxInflightStatic, MaxInflightAdaptive)
+		}
+		c.MaxInflightMode = val
+	}
+
+	c.AdaptiveMinInflight = getInt(envMap, "adaptive_min_inflight", 1)
+	c.AdaptiveMaxInflight = getInt(envMap, "adaptive_max_inflight", 1000)
+	c.AdaptiveWindow = getDuration(envMap, "adaptive_window", time.Second)
+	c.AdaptiveLatencyTolerance = getFloat(envMap, "adaptive_latency_tolerance", 2.0)
+
+	if c.AdaptiveMinInflight < 1 || c.AdaptiveMinInflight > c.AdaptiveMaxInflight {
+		return c, fmt.Errorf("invalid adaptive_min_inflight or adaptive_max_inflight, the minimum must be at least 1 and not above the maximum")
+	}
+	if c.AdaptiveWindow <= 0 {
+		return c, fmt.Errorf("invalid adaptive_window value: %s, must be over 0s", c.AdaptiveWindow)
+	}
+	if c.AdaptiveLatencyTolerance < 1 {
+		return c, fmt.Errorf("invalid adaptive_latency_tolerance value: %f, must be at least 1", c.AdaptiveLatencyTolerance)
+	}
+
+	c.PriorityLanes = getBool(envMap, "priority_lanes")
+	c.PriorityHeader = "X-Priority"
+	if val, exists := envMap["priority_header"]; exists && len(val) > 0 {
+		c.PriorityHeader = val
+	}
+	c.PriorityClaim = envMap["priority_claim"]
+	if len(c.PriorityClaim) > 0 && !getBool(envMap, "jwt_auth") {
+		return c, fmt.Errorf("priority_claim requires jwt_auth=true, since the claim must come from a verified token")
+	}
+	c.PriorityReservedShare = getFloat(envMap, "priority_reserved_share", 0.2)
+	if c.PriorityReservedShare < 0 || c.PriorityReservedShare >= 1 {
+		return c, fmt.Errorf("invalid priority_reserved_share value: %f, must be at least 0 and less than 1", c.PriorityReservedShare)
+	}
+
+	c.PriorityDefault = PriorityLow
+	if val, exists := envMap["priority_default"]; exists {
+		if val != PriorityHigh && val != PriorityLow {
+			return c, fmt.Errorf("invalid priority_default value: %s, use %q or %q", val, PriorityHigh, PriorityLow)
+		}
+		c.PriorityDefault = val
+	}
+
+	c.MemoryCgroupPath = "/sys/fs/cgroup"
+	if val, exists := envMap["memory_cgroup_path"]; exists && len(val) > 0 {
+		c.MemoryCgroupPath = val
+	}
+	c.MemoryHighWatermark = getFloat(envMap, "memory_high_watermark", 0)
+	c.MemoryLowWatermark = getFloat(envMap, "memory_low_watermark", c.MemoryHighWatermark*0.9)
+	c.MemoryCheckInterval = getDuration(envMap, "memory_check_interval", time.Second)
+
+	if c.MemoryHighWatermark < 0 || c.MemoryHighWatermark > 1 {
+		return c, fmt.Errorf("invalid memory_high_watermark value: %f, must be between 0 and 1", c.MemoryHighWatermark)
+	}
+	if c.MemoryLowWatermark > c.MemoryHighWatermark {
+		return c, fmt.Errorf("memory_low_watermark must not be above memory_high_watermark")
+	}
+	if c.MemoryCheckInterval <= 0 {
+		return c, fmt.Errorf("invalid memory_check_interval value: %s, must be over 0s", c.MemoryCheckInterval)
+	}
+
+	if val, exists := envMap["limit_rules_file"]; exists && len(val) > 0 {
+		rules, err := loadLimitRules(val)
+		if err != nil {
+			return c, err
+		}
+		c.LimitRules = rules
+	}
+
+	if val, exists := envMap["health_checks_file"]; exists && len(val) > 0 {
+		checks, err := loadHealthChecks(val)
+		if err != nil {
+			return c, err
+		}
+		c.HealthChecks = checks
+	}
+
+	if val, exists := envMap["warmup_file"]; exists && len(val) > 0 {
+		requests, err := loadWarmup(val)
+		if err != nil {
+			return c, err
+		}
+		c.Warmup = requests
+	}
+	c.WarmupTimeout = getDuration(envMap, "warmup_timeout", 30*time.Second)
+	if c.WarmupTimeout <= 0 {
+		return c, fmt.Errorf("invalid warmup_timeout value: %s, must be over 0s", c.WarmupTimeout)
+	}
+
+	c.LogFormat = LogFormatText
+	if val, exists := envMap["log_format"]; exists && len(val) > 0 {
+		if val != LogFormatText && val != LogFormatJSON {
+			return c, fmt.Errorf("invalid log_format value: %s, use %q or %q", val, LogFormatText, LogFormatJSON)
+		}
+		c.LogFormat = val
+	}
+
+	c.LogOverflow = LogOverflowSplit
+	if val, exists := envMap["log_overflow"]; exists && len(val) > 0 {
+		if val != LogOverflowSplit && val != LogOverflowTruncate {
+			return c, fmt.Errorf("invalid log_overflow value: %s, use %q or %q", val, LogOverflowSplit, LogOverflowTruncate)
+		}
+		c.LogOverflow = val
+	}
+
+	c.LogMultiline = getBool(envMap, "log_multiline")
+	c.LogMultilineTimeout = getDuration(envMap, "log_multiline_timeout", 500*time.Millisecond)
+	c.LogMultilineMaxBytes = getInt(envMap, "log_multiline_max_bytes", 64*1024)
+
+	if val, exists := envMap["log_multiline_start"]; exists && len(val) > 0 {
+		re, err := regexp.Compile(val)
+		if err != nil {
+			return c, fmt.Errorf("invalid log_multiline_start value: %s, error: %w", val, err)
+		}
+		c.LogMultilineStart = re
+	}
+
+	continuePattern := `^(\s|Caused by:)`
+	if val, exists := envMap["log_multiline_continue"]; exists {
+		continuePattern = val
+	}
+	if len(continuePattern) > 0 {
+		re, err := regexp.Compile(continuePattern)
+		if err != nil {
+			return c, fmt.Errorf("invalid log_multiline_continue value: %s, error: %w", continuePattern, err)
+		}
+		c.LogMultilineContinue = re
+	}
+
+	if c.LogMultiline && c.LogMultilineTimeout <= 0 {
+		return c, fmt.Errorf("invalid log_multiline_timeout value: %s, must be over 0s", c.LogMultilineTimeout)
+	}
+	if c.LogMultiline && c.LogMultilineMaxBytes <= 0 {
+		return c, fmt.Errorf("invalid log_multiline_max_bytes value: %d, must be over 0", c.LogMultilineMaxBytes)
+	}
+
+	c.LogRateLimit = getFloat(envMap, "log_rate_limit", 0)
+	c.LogRateBurst = getInt(envMap, "log_rate_burst", int(math.Max(1, math.Ceil(c.LogRateLimit))))
+	c.LogRateSample = getInt(envMap, "log_rate_sample", 0)
+	c.LogRateSummaryInterval = getDuration(envMap, "log_rate_summary_interval", 10*time.Second)
+
+	if c.LogRateLimit < 0 || c.LogRateBurst < 1 || c.LogRateSample < 0 {
+		return c, fmt.Errorf("invalid log rate limit, log_rate_limit and log_rate_sample must not be negative and log_rate_burst must be at least 1")
+	}
+	if c.LogRateSummaryInterval <= 0 {
+		return c, fmt.Errorf("invalid log_rate_summary_interval value: %s, must be over 0s", c.LogRateSummaryInterval)
+	}
+
+	errorPattern := `(?i)\b(error|fatal|panic|exception|traceback)\b`
+	if val, exists := envMap["log_error_pattern"]; exists {
+		errorPattern = val
+	}
+	if len(errorPattern) > 0 {
+		re, err := regexp.Compile(errorPattern)
+		if err != nil {
+			return c, fmt.Errorf("invalid log_error_pattern value: %s, error: %w", errorPattern, err)
+		}
+		c.LogErrorPattern = re
+	}
+
+	c.LogSyslogAddress = envMap["log_syslog_address"]
+	c.LogSyslogTag = envMap["log_syslog_tag"]
+
+	c.LogFile = envMap["log_file"]
+	c.LogFileMaxSize = int64(getInt(envMap, "log_file_max_size", 100*1024*1024))
+	c.LogFileMaxAge = getDuration(envMap, "log_file_max_age", 0)
+	c.LogFileMaxBackups = getInt(envMap, "log_file_max_backups", 5)
+	c.LogFileCompress = getBool(envMap, "log_file_compress")
+
+	c.AccessLogFormat = AccessLogText
+	if val, exists := envMap["access_log_format"]; exists && len(val) > 0 {
+		if val != AccessLogText && val != AccessLogJSON && val != AccessLogLogfmt {
+			return c, fmt.Errorf("invalid access_log_format value: %s, use %q, %q or %q", val, AccessLogText, AccessLogJSON, AccessLogLogfmt)
+		}
+		c.AccessLogFormat = val
+	}
+
+	c.AccessLogSkipUserAgents = []string{"kube-probe"}
+	if val, exists := envMap["access_log_skip_user_agents"]; exists {
+		c.AccessLogSkipUserAgents = nil
+		for _, agent := range strings.Split(val, ",") {
+			if agent = strings.TrimSpace(agent); len(agent) > 0 {
+				c.AccessLogSkipUserAgents = append(c.AccessLogSkipUserAgents, agent)
+			}
+		}
+	}
+
+	c.MetricsReadTimeout = getDuration(envMap, "metrics_read_timeout", 500*time.Millisecond)
+	c.MetricsWriteTimeout = getDuration(envMap, "metrics_write_timeout", 500*time.Millisecond)
+	c.MetricsFunctionLabels = getBool(envMap, "metrics_function_labels")
+	c.MetricsNativeHistograms = getBool(envMap, "metrics_native_histograms")
+	c.MetricsExemplars = getBool(envMap, "metrics_exemplars")
+
+	for _, bucket := range strings.Split(envMap["metrics_buckets"], ",") {
+		if bucket = strings.TrimSpace(bucket); len(bucket) > 0 {
+			upper, err := strconv.ParseFloat(bucket, 64)
+			if err != nil || upper <= 0 {
+				return c, fmt.Errorf("invalid metrics_buckets value: %s, each bucket must be a number of seconds over 0", bucket)
+			}
+			if n := len(c.MetricsBuckets); n > 0 && upper <= c.MetricsBuckets[n-1] {
+				return c, fmt.Errorf("invalid metrics_buckets value: %s, buckets must be in increasing order", bucket)
+			}
+			c.MetricsBuckets = append(c.MetricsBuckets, upper)
+		}
+	}
+
+	c.ProcessMaxRSS = int64(getInt(envMap, "process_max_rss", 0))
+	c.ProcessCheckInterval = getDuration(envMap, "process_check_interval", 5*time.Second)
+	if c.ProcessMaxRSS < 0 || c.ProcessCheckInterval <= 0 {
+		return c, fmt.Errorf("invalid process_max_rss or process_check_interval, must not be negative and the interval must be over 0s")
+	}
+
+	c.LockPath = DefaultLockPath()
+	if val, exists := envMap["lock_path"]; exists && len(val) > 0 {
+		if !filepath.IsAbs(val) {
+			return c, fmt.Errorf("invalid lock_path value: %s, must be an absolute path", val)
+		}
+		c.LockPath = val
+	}
+
+	c.ReadyInterval = getDuration(envMap, "ready_interval", 0)
+	c.ReadyTimeout = getDuration(envMap, "ready_timeout", time.Second)
+	c.ReadySuccessThreshold = getInt(envMap, "ready_success_threshold", 1)
+	c.ReadyFailureThreshold = getInt(envMap, "ready_failure_threshold", 3)
+	if c.ReadyInterval < 0 || c.ReadyTimeout <= 0 {
+		return c, fmt.Errorf("invalid ready_interval or ready_timeout, the interval must not be negative and the timeout must be over 0s")
+	}
+	if c.ReadySuccessThreshold < 1 || c.ReadyFailureThreshold < 1 {
+		return c, fmt.Errorf("invalid ready_success_threshold or ready_failure_threshold, must be at least 1")
+	}
+
+	c.IdleTimeout = getDuration(envMap, "idle_timeout", 0)
+	if c.IdleTimeout < 0 {
+		return c, fmt.Errorf("invalid idle_timeout value: %s, must not be negative", c.IdleTimeout)
+	}
+	c.IdleExitCode = getInt(envMap, "idle_exit_code", 0)
+	if c.IdleExitCode < 0 || c.IdleExitCode > 255 {
+		return c, fmt.Errorf("invalid idle_exit_code value: %d, must be between 0 and 255", c.IdleExitCode)
+	}
+	c.IdleHook = envMap["idle_hook"]
+
+	c.StatsDAddress = envMap["statsd_address"]
+	if len(c.StatsDAddress) > 0 {
+		host, _, err := net.SplitHostPort(c.StatsDAddress)
+		if err != nil {
+			return c, fmt.Errorf("invalid statsd_address value: %s, error: %w", c.StatsDAddress, err)
+		}
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return c, fmt.Errorf("invalid statsd_address value: %s, the host must be localhost or a loopback address", c.StatsDAddress)
+		}
+	}
+
+	c.StatsDPrefix = "custom"
+	if val, exists := envMap["statsd_prefix"]; exists {
+		c.StatsDPrefix = val
+	}
+	if !metricPrefix.MatchString(c.StatsDPrefix) {
+		return c, fmt.Errorf("invalid statsd_prefix value: %s, must be a valid Prometheus metric name", c.StatsDPrefix)
+	}
+
+	c.StatsDMaxSeries = getInt(envMap, "statsd_max_series", 1000)
+	if c.StatsDMaxSeries <= 0 {
+		return c, fmt.Errorf("invalid statsd_max_series value: %d, must be over 0", c.StatsDMaxSeries)
+	}
+
+	for _, route := range strings.Split(envMap["metrics_routes"], ",") {
+		if route = strings.TrimSpace(route); len(route) > 0 {
+			if !strings.HasPrefix(route, "/") {
+				return c, fmt.Errorf("invalid metrics_routes value: %s, each route must start with /", route)
+			}
+			c.MetricsRoutes = append(c.MetricsRoutes, route)
+		}
+	}
+
+	c.TraceOTLPEndpoint = envMap["trace_otlp_endpoint"]
+	c.TraceSampleRatio = getFloat(envMap, "trace_sample_ratio", 1.0)
+	if c.TraceSampleRatio < 0 || c.TraceSampleRatio > 1 {
+		return c, fmt.Errorf("invalid trace_sample_ratio value: %f, must be between 0 and 1", c.TraceSampleRatio)
+	}
+
 	if val := envMap["mode"]; len(val) > 0 {
 		c.OperationalMode = WatchdogModeConst(val)
 	}
@@ -192,6 +764,12 @@ func New(env []string) (WatchdogConfig, error) {
 		return c, fmt.Errorf(`provide a "function_process" or "fprocess" environmental variable for your function`)
 	}
 
+	if c.OperationalMode == ModeInproc {
+		if err := c.ValidateInproc(); err != nil {
+			return c, err
+		}
+	}
+
 	c.JWTAuthentication = getBool(envMap, "jwt_auth")
 	c.JWTAuthDebug = getBool(envMap, "jwt_auth_debug")
 	c.JWTAuthLocal = getBool(envMap, "jwt_auth_local")
@@ -240,6 +818,26 @@ func parseIntOrDurationValue(val string, fallback time.Duration) time.Duration {
 	return duration
 }
 
+// Port returns the port the watchdog listens on from env, given as
+// KEY=value pairs like New, or 8080 when it is not set.
+func Port(env []string) (int, error) {
+	return getPort(mapEnv(env))
+}
+
+func getPort(env map[string]string) (int, error) {
+	val, exists := env["port"]
+	if !exists || len(val) == 0 {
+		return 8080, nil
+	}
+
+	port, err := strconv.Atoi(val)
+	if err != nil || port <= 0 || port > 65535 {
+		return 0, fmt.Errorf("invalid port value: %s, must be between 1 and 65535", val)
+	}
+
+	return port, nil
+}
+
 func getInt(env map[string]string, key string, defaultValue int) int {
 	result := defaultValue
 	if val, exists := env[key]; exists {
@@ -251,6 +849,16 @@ func getInt(env map[string]string, key string, defaultValue int) int {
 	return result
 }
 
+func getFloat(env map[string]string, key string, defaultValue float64) float64 {
+	if val, exists := env[key]; exists {
+		if parsed, err := strconv.ParseFloat(val, 64); err == nil {
+			return parsed
+		}
+	}
+
+	return defaultValue
+}
+
 func getBool(env map[string]string, key string) bool {
 	if env[key] == "true" || env[key] == "1" {
 		return true
@@ -6,6 +6,9 @@ package config
 import (
 	"bufio"
 	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -232,6 +235,19 @@ func Test_PortOverride(t *testing.T) {
 	if actual.TCPPort != 8081 {
 		t.Errorf("Want %d. got: %d", 8081, actual.TCPPort)
 	}
+
+	for _, port := range []string{"http", "0", "65536"} {
+		if _, err := New([]string{"port=" + port}); err == nil {
+			t.Errorf("Want error for port of %s", port)
+		}
+		if _, err := Port([]string{"port=" + port}); err == nil {
+			t.Errorf("Want error from Port for port of %s", port)
+		}
+	}
+
+	if port, err := Port([]string{}); err != nil || port != 8080 {
+		t.Errorf("Want port 8080 by default. got: %d, %v", port, err)
+	}
 }
 
 func Test_Timeouts(t *testing.T) {
@@ -305,6 +321,14 @@ func Test_InprocAllowsNoFprocess(t *testing.T) {
 
 }
 
+func Test_InprocRejectsFunctionLogOptions(t *testing.T) {
+	for _, option := range []string{"log_format=json", "log_overflow=truncate", "log_multiline=true", "log_rate_limit=10"} {
+		if _, err := New([]string{"mode=inproc", option}); err == nil {
+			t.Errorf("Want error for %s in inproc mode", option)
+		}
+	}
+}
+
 func Test_TestNonDurationValue_getDuration(t *testing.T) {
 	want := 10 * time.Second
 	env := map[string]string{"time": "10"}
@@ -389,3 +413,659 @@ func Test_NonParsableString_parseIntOrDurationValue(t *testing.T) {
 		t.Error(fmt.Sprintf("want: %q got: %q", want, got))
 	}
 }
+
+func Test_MaxInflightQueue(t *testing.T) {
+	env := []string{
+		"max_inflight=2",
+		"max_inflight_queue=10",
+		"max_inflight_queue_timeout=5s",
+	}
+
+	actual, _ := New(env)
+
+	if actual.MaxInflightQueue != 10 {
+		t.Errorf("Want MaxInflightQueue %d. got: %d", 10, actual.MaxInflightQueue)
+	}
+	if actual.MaxInflightQueueTimeout != 5*time.Second {
+		t.Errorf("Want MaxInflightQueueTimeout %s. got: %s", 5*time.Second, actual.MaxInflightQueueTimeout)
+	}
+
+	for _, timeout := range []string{"0s", "-1s"} {
+		if _, err := New([]string{"fprocess=cat", "max_inflight=2", "max_inflight_queue=10", "max_inflight_queue_timeout=" + timeout}); err == nil {
+			t.Errorf("Want error for max_inflight_queue_timeout of %s", timeout)
+		}
+	}
+}
+
+func Test_MaxInflightQueueTimeout_DefaultsToWriteTimeout(t *testing.T) {
+	env := []string{
+		"write_timeout=20s",
+	}
+
+	actual, _ := New(env)
+
+	if actual.MaxInflightQueueTimeout != 20*time.Second {
+		t.Errorf("Want MaxInflightQueueTimeout %s. got: %s", 20*time.Second, actual.MaxInflightQueueTimeout)
+	}
+}
+
+func Test_MaxInflightMode(t *testing.T) {
+	defaults, _ := New([]string{})
+	if defaults.MaxInflightMode != MaxInflightStatic {
+		t.Errorf("Want MaxInflightMode %s. got: %s", MaxInflightStatic, defaults.MaxInflightMode)
+	}
+
+	actual, _ := New([]string{
+		"max_inflight_mode=adaptive",
+		"adaptive_min_inflight=2",
+		"adaptive_max_inflight=50",
+		"adaptive_window=5s",
+		"adaptive_latency_tolerance=1.5",
+	})
+
+	if actual.MaxInflightMode != MaxInflightAdaptive {
+		t.Errorf("Want MaxInflightMode %s. got: %s", MaxInflightAdaptive, actual.MaxInflightMode)
+	}
+	if actual.AdaptiveMinInflight != 2 || actual.AdaptiveMaxInflight != 50 {
+		t.Errorf("Want adaptive bounds 2-50. got: %d-%d", actual.AdaptiveMinInflight, actual.AdaptiveMaxInflight)
+	}
+	if actual.AdaptiveWindow != 5*time.Second {
+		t.Errorf("Want AdaptiveWindow %s. got: %s", 5*time.Second, actual.AdaptiveWindow)
+	}
+	if actual.AdaptiveLatencyTolerance != 1.5 {
+		t.Errorf("Want AdaptiveLatencyTolerance 1.5. got: %f", actual.AdaptiveLatencyTolerance)
+	}
+}
+
+func Test_MaxInflightMode_Invalid(t *testing.T) {
+	_, err := New([]string{"fprocess=cat", "max_inflight_mode=dynamic"})
+	if err == nil {
+		t.Fatal("Want error for invalid max_inflight_mode")
+	}
+
+	for _, env := range [][]string{
+		{"adaptive_min_inflight=0"},
+		{"adaptive_min_inflight=10", "adaptive_max_inflight=5"},
+		{"adaptive_window=0s"},
+		{"adaptive_latency_tolerance=0.5"},
+	} {
+		if _, err := New(append([]string{"fprocess=cat", "max_inflight_mode=adaptive"}, env...)); err == nil {
+			t.Errorf("Want error for %v", env)
+		}
+	}
+}
+
+func Test_LimitRulesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	rules := `[
+  {"name": "probe", "path_prefix": "/healthz", "methods": ["GET"], "rate": 10},
+  {"name": "render", "path_prefix": "/render", "headers": {"X-Tier": "free"}, "max_inflight": 2}
+]`
+	if err := os.WriteFile(path, []byte(rules), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := New([]string{"fprocess=cat", "limit_rules_file=" + path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(actual.LimitRules) != 2 {
+		t.Fatalf("Want 2 rules. got: %d", len(actual.LimitRules))
+	}
+	if actual.LimitRules[0].Burst != 1 {
+		t.Errorf("Want Burst to default to 1. got: %d", actual.LimitRules[0].Burst)
+	}
+	if actual.LimitRules[1].Headers["X-Tier"] != "free" {
+		t.Errorf("Want X-Tier header match. got: %v", actual.LimitRules[1].Headers)
+	}
+}
+
+func Test_LimitRulesFile_Invalid(t *testing.T) {
+	cases := map[string]string{
+		"no name":   `[{"path_prefix": "/"}]`,
+		"duplicate": `[{"name": "a"}, {"name": "a"}]`,
+		"negative":  `[{"name": "a", "max_inflight": -1}]`,
+		"not json":  `rules:`,
+	}
+
+	for name, rules := range cases {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rules.json")
+			if err := os.WriteFile(path, []byte(rules), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := New([]string{"fprocess=cat", "limit_rules_file=" + path}); err == nil {
+				t.Fatal("Want error for invalid rules")
+			}
+		})
+	}
+}
+
+func Test_HealthChecksFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checks.json")
+	checks := `[
+  {"name": "db", "type": "tcp", "address": "127.0.0.1:5432", "interval": "30s", "timeout": "2s"},
+  {"name": "model", "type": "file", "path": "/models/ready", "liveness": true}
+]`
+	if err := os.WriteFile(path, []byte(checks), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := New([]string{"fprocess=cat", "health_checks_file=" + path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(actual.HealthChecks) != 2 {
+		t.Fatalf("Want 2 checks. got: %d", len(actual.HealthChecks))
+	}
+	if db := actual.HealthChecks[0]; db.Interval != 30*time.Second || db.Timeout != 2*time.Second {
+		t.Errorf("Want interval of 30s and timeout of 2s. got: %s, %s", db.Interval, db.Timeout)
+	}
+	if model := actual.HealthChecks[1]; model.Interval != 10*time.Second || model.Timeout != time.Second || !model.Liveness {
+		t.Errorf("Want default interval of 10s and timeout of 1s for a liveness check. got: %s, %s, %t", model.Interval, model.Timeout, model.Liveness)
+	}
+}
+
+func Test_HealthChecksFile_Invalid(t *testing.T) {
+	cases := map[string]string{
+		"no name":      `[{"type": "file", "path": "/tmp"}]`,
+		"duplicate":    `[{"name": "a", "type": "file", "path": "/tmp"}, {"name": "a", "type": "file", "path": "/tmp"}]`,
+		"unknown type": `[{"name": "a", "type": "grpc", "address": "127.0.0.1:50051"}]`,
+		"no target":    `[{"name": "a", "type": "http"}]`,
+		"long timeout": `[{"name": "a", "type": "file", "path": "/tmp", "interval": "1s", "timeout": "2s"}]`,
+		"bad duration": `[{"name": "a", "type": "file", "path": "/tmp", "interval": "soon"}]`,
+		"not json":     `checks:`,
+	}
+
+	for name, checks := range cases {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "checks.json")
+			if err := os.WriteFile(path, []byte(checks), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := New([]string{"fprocess=cat", "health_checks_file=" + path}); err == nil {
+				t.Fatal("Want error for invalid checks")
+			}
+		})
+	}
+}
+
+func Test_WarmupFile(t *testing.T) {
+	dir := t.TempDir()
+	body := filepath.Join(dir, "body.json")
+	if err := os.WriteFile(body, []byte(`{"text": "warm"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "warmup.json")
+	requests := `[
+  {},
+  {"method": "post", "path": "/predict", "body_file": "` + body + `", "headers": {"Content-Type": "application/json"}, "status": 202}
+]`
+	if err := os.WriteFile(path, []byte(requests), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := New([]string{"fprocess=cat", "warmup_file=" + path, "warmup_timeout=2m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(actual.Warmup) != 2 || actual.WarmupTimeout != 2*time.Minute {
+		t.Fatalf("Want 2 requests with a timeout of 2m. got: %d, %s", len(actual.Warmup), actual.WarmupTimeout)
+	}
+	if first := actual.Warmup[0]; first.Method != http.MethodGet || first.Path != "/" || first.Status != http.StatusOK {
+		t.Errorf("Want GET / expecting 200 by default. got: %s %s %d", first.Method, first.Path, first.Status)
+	}
+	if second := actual.Warmup[1]; second.Method != http.MethodPost || string(second.Body) != `{"text": "warm"}` || second.Status != http.StatusAccepted {
+		t.Errorf("Want POST with the body from body_file expecting 202. got: %s %q %d", second.Method, second.Body, second.Status)
+	}
+}
+
+func Test_WarmupFile_Invalid(t *testing.T) {
+	cases := map[string]string{
+		"relative path":  `[{"path": "predict"}]`,
+		"invalid status": `[{"status": 999}]`,
+		"missing body":   `[{"body_file": "/does/not/exist"}]`,
+		"not json":       `requests:`,
+	}
+
+	for name, requests := range cases {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "warmup.json")
+			if err := os.WriteFile(path, []byte(requests), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := New([]string{"fprocess=cat", "warmup_file=" + path}); err == nil {
+				t.Fatal("Want error for invalid warm-up requests")
+			}
+		})
+	}
+}
+
+func Test_PriorityLanes(t *testing.T) {
+	defaults, _ := New([]string{})
+	if defaults.PriorityHeader != "X-Priority" {
+		t.Errorf("Want PriorityHeader X-Priority. got: %s", defaults.PriorityHeader)
+	}
+	if defaults.PriorityDefault != PriorityLow {
+		t.Errorf("Want PriorityDefault %s. got: %s", PriorityLow, defaults.PriorityDefault)
+	}
+
+	actual, err := New([]string{
+		"fprocess=cat",
+		"priority_lanes=true",
+		"priority_claim=tier",
+		"jwt_auth=true",
+		"priority_reserved_share=0.5",
+		"priority_default=high",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !actual.PriorityLanes || actual.PriorityClaim != "tier" || actual.PriorityReservedShare != 0.5 || actual.PriorityDefault != PriorityHigh {
+		t.Errorf("Want priority settings to be parsed. got: %+v", actual)
+	}
+
+	if _, err := New([]string{"fprocess=cat", "priority_reserved_share=1"}); err == nil {
+		t.Error("Want error for priority_reserved_share of 1")
+	}
+
+	if _, err := New([]string{"fprocess=cat", "priority_lanes=true", "priority_claim=tier"}); err == nil {
+		t.Error("Want error for priority_claim without jwt_auth")
+	}
+}
+
+func Test_MemoryWatermarks(t *testing.T) {
+	defaults, _ := New([]string{})
+	if defaults.MemoryCgroupPath != "/sys/fs/cgroup" {
+		t.Errorf("Want MemoryCgroupPath /sys/fs/cgroup. got: %s", defaults.MemoryCgroupPath)
+	}
+	if defaults.MemoryHighWatermark != 0 {
+		t.Errorf("Want memory guard disabled by default. got: %f", defaults.MemoryHighWatermark)
+	}
+
+	actual, err := New([]string{"fprocess=cat", "memory_high_watermark=0.9", "memory_cgroup_path=/tmp/cgroup"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual.MemoryLowWatermark != 0.9*0.9 {
+		t.Errorf("Want MemoryLowWatermark to default to 90%% of the high watermark. got: %f", actual.MemoryLowWatermark)
+	}
+	if actual.MemoryCgroupPath != "/tmp/cgroup" {
+		t.Errorf("Want MemoryCgroupPath /tmp/cgroup. got: %s", actual.MemoryCgroupPath)
+	}
+
+	if _, err := New([]string{"fprocess=cat", "memory_high_watermark=0.8", "memory_low_watermark=0.9"}); err == nil {
+		t.Error("Want error when the low watermark is above the high watermark")
+	}
+
+	for _, interval := range []string{"0s", "-1s"} {
+		if _, err := New([]string{"fprocess=cat", "memory_high_watermark=0.9", "memory_check_interval=" + interval}); err == nil {
+			t.Errorf("Want error for memory_check_interval=%s", interval)
+		}
+	}
+}
+
+func Test_Tracing(t *testing.T) {
+	defaults, _ := New([]string{})
+	if len(defaults.TraceOTLPEndpoint) > 0 {
+		t.Errorf("Want tracing disabled by default. got endpoint: %s", defaults.TraceOTLPEndpoint)
+	}
+	if defaults.TraceSampleRatio != 1.0 {
+		t.Errorf("Want TraceSampleRatio 1.0. got: %f", defaults.TraceSampleRatio)
+	}
+
+	actual, err := New([]string{"fprocess=cat", "trace_otlp_endpoint=http://otel-collector:4318", "trace_sample_ratio=0.25"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual.TraceOTLPEndpoint != "http://otel-collector:4318" {
+		t.Errorf("Want TraceOTLPEndpoint http://otel-collector:4318. got: %s", actual.TraceOTLPEndpoint)
+	}
+	if actual.TraceSampleRatio != 0.25 {
+		t.Errorf("Want TraceSampleRatio 0.25. got: %f", actual.TraceSampleRatio)
+	}
+
+	if _, err := New([]string{"fprocess=cat", "trace_sample_ratio=1.5"}); err == nil {
+		t.Error("Want error when trace_sample_ratio is above 1")
+	}
+}
+
+func Test_AccessLog(t *testing.T) {
+	defaults, _ := New([]string{})
+	if defaults.AccessLogFormat != AccessLogText {
+		t.Errorf("Want AccessLogFormat %s. got: %s", AccessLogText, defaults.AccessLogFormat)
+	}
+	if len(defaults.AccessLogSkipUserAgents) != 1 || defaults.AccessLogSkipUserAgents[0] != "kube-probe" {
+		t.Errorf("Want kube-probe skipped by default. got: %v", defaults.AccessLogSkipUserAgents)
+	}
+
+	actual, err := New([]string{"fprocess=cat", "access_log_format=json", "access_log_skip_user_agents="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual.AccessLogFormat != AccessLogJSON {
+		t.Errorf("Want AccessLogFormat %s. got: %s", AccessLogJSON, actual.AccessLogFormat)
+	}
+	if len(actual.AccessLogSkipUserAgents) != 0 {
+		t.Errorf("Want no user agents skipped. got: %v", actual.AccessLogSkipUserAgents)
+	}
+
+	if _, err := New([]string{"fprocess=cat", "access_log_format=xml"}); err == nil {
+		t.Error("Want error for an unknown access_log_format")
+	}
+}
+
+func Test_LogFormat(t *testing.T) {
+	defaults, _ := New([]string{})
+	if defaults.LogFormat != LogFormatText {
+		t.Errorf("Want LogFormat %s. got: %s", LogFormatText, defaults.LogFormat)
+	}
+
+	actual, err := New([]string{"fprocess=cat", "log_format=json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual.LogFormat != LogFormatJSON {
+		t.Errorf("Want LogFormat %s. got: %s", LogFormatJSON, actual.LogFormat)
+	}
+
+	if _, err := New([]string{"fprocess=cat", "log_format=yaml"}); err == nil {
+		t.Error("Want error for an unknown log_format")
+	}
+}
+
+func Test_LogOverflow(t *testing.T) {
+	defaults, _ := New([]string{})
+	if defaults.LogOverflow != LogOverflowSplit {
+		t.Errorf("Want LogOverflow %s. got: %s", LogOverflowSplit, defaults.LogOverflow)
+	}
+
+	actual, err := New([]string{"fprocess=cat", "log_overflow=truncate"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual.LogOverflow != LogOverflowTruncate {
+		t.Errorf("Want LogOverflow %s. got: %s", LogOverflowTruncate, actual.LogOverflow)
+	}
+
+	if _, err := New([]string{"fprocess=cat", "log_overflow=drop"}); err == nil {
+		t.Error("Want error for an unknown log_overflow")
+	}
+}
+
+func Test_LogMultiline(t *testing.T) {
+	defaults, _ := New([]string{})
+	if defaults.LogMultiline {
+		t.Error("Want multiline logs disabled by default")
+	}
+	if defaults.LogMultilineContinue == nil || !defaults.LogMultilineContinue.MatchString("\tat com.example.Main") {
+		t.Errorf("Want indented lines to continue a record by default")
+	}
+	if defaults.LogMultilineTimeout != 500*time.Millisecond {
+		t.Errorf("Want LogMultilineTimeout 500ms. got: %s", defaults.LogMultilineTimeout)
+	}
+
+	actual, err := New([]string{"fprocess=cat", "log_multiline=true", "log_multiline_start=^\\d{4}-", "log_multiline_continue="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !actual.LogMultiline || actual.LogMultilineStart == nil || !actual.LogMultilineStart.MatchString("2024-04-25 started") {
+		t.Errorf("Want multiline logs with a start pattern. got: %v", actual.LogMultilineStart)
+	}
+	if actual.LogMultilineContinue != nil {
+		t.Errorf("Want no continue pattern. got: %s", actual.LogMultilineContinue)
+	}
+
+	if _, err := New([]string{"fprocess=cat", "log_multiline_start=("}); err == nil {
+		t.Error("Want error for an invalid log_multiline_start")
+	}
+
+	for _, option := range []string{"log_multiline_timeout=0s", "log_multiline_timeout=-1s", "log_multiline_max_bytes=0", "log_multiline_max_bytes=-1"} {
+		if _, err := New([]string{"fprocess=cat", "log_multiline=true", option}); err == nil {
+			t.Errorf("Want error for %s", option)
+		}
+	}
+}
+
+func Test_LogRateLimit(t *testing.T) {
+	defaults, _ := New([]string{})
+	if defaults.LogRateLimit != 0 {
+		t.Errorf("Want no log rate limit by default. got: %f", defaults.LogRateLimit)
+	}
+	if defaults.LogErrorPattern == nil || !defaults.LogErrorPattern.MatchString("Fatal error: out of memory") {
+		t.Error("Want error lines matched by default")
+	}
+
+	actual, err := New([]string{"fprocess=cat", "log_rate_limit=100", "log_rate_sample=10", "log_error_pattern="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual.LogRateLimit != 100 || actual.LogRateBurst != 100 || actual.LogRateSample != 10 {
+		t.Errorf("Want rate 100, burst 100 and sample 10. got: %f, %d, %d", actual.LogRateLimit, actual.LogRateBurst, actual.LogRateSample)
+	}
+	if actual.LogErrorPattern != nil {
+		t.Errorf("Want no error pattern. got: %s", actual.LogErrorPattern)
+	}
+
+	if _, err := New([]string{"fprocess=cat", "log_rate_limit=-1"}); err == nil {
+		t.Error("Want error for a negative log_rate_limit")
+	}
+	if _, err := New([]string{"fprocess=cat", "log_rate_limit=100", "log_rate_summary_interval=0s"}); err == nil {
+		t.Error("Want error for a log_rate_summary_interval of 0s")
+	}
+}
+
+func Test_LogSinks(t *testing.T) {
+	defaults, _ := New([]string{})
+	if len(defaults.LogSyslogAddress) > 0 || len(defaults.LogFile) > 0 {
+		t.Errorf("Want no log sinks by default. got: %q, %q", defaults.LogSyslogAddress, defaults.LogFile)
+	}
+	if defaults.LogFileMaxSize != 100*1024*1024 || defaults.LogFileMaxBackups != 5 {
+		t.Errorf("Want 100MB files with 5 backups. got: %d, %d", defaults.LogFileMaxSize, defaults.LogFileMaxBackups)
+	}
+
+	actual, err := New([]string{"fprocess=cat",
+		"log_syslog_address=udp://127.0.0.1:514",
+		"log_file=/var/log/function.log",
+		"log_file_max_age=24h",
+		"log_file_compress=true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual.LogSyslogAddress != "udp://127.0.0.1:514" || actual.LogFile != "/var/log/function.log" {
+		t.Errorf("Want syslog and file sinks. got: %q, %q", actual.LogSyslogAddress, actual.LogFile)
+	}
+	if actual.LogFileMaxAge != 24*time.Hour || !actual.LogFileCompress {
+		t.Errorf("Want max age of 24h with compression. got: %s, %v", actual.LogFileMaxAge, actual.LogFileCompress)
+	}
+}
+
+func Test_MetricsRoutes(t *testing.T) {
+	defaults, _ := New([]string{})
+	if len(defaults.MetricsRoutes) != 0 {
+		t.Errorf("Want no metrics routes by default. got: %v", defaults.MetricsRoutes)
+	}
+
+	actual, err := New([]string{"fprocess=cat", "metrics_routes=/users/{id}, /orders/{rest...}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/users/{id}", "/orders/{rest...}"}
+	if len(actual.MetricsRoutes) != 2 || actual.MetricsRoutes[0] != want[0] || actual.MetricsRoutes[1] != want[1] {
+		t.Errorf("Want MetricsRoutes %v. got: %v", want, actual.MetricsRoutes)
+	}
+
+	if _, err := New([]string{"fprocess=cat", "metrics_routes=users"}); err == nil {
+		t.Error("Want error for a route which does not start with /")
+	}
+}
+
+func Test_MetricsServer(t *testing.T) {
+	defaults, _ := New([]string{})
+	if defaults.MetricsPort != 8081 {
+		t.Errorf("Want MetricsPort 8081. got: %d", defaults.MetricsPort)
+	}
+	if defaults.MetricsReadTimeout != 500*time.Millisecond || defaults.MetricsWriteTimeout != 500*time.Millisecond {
+		t.Errorf("Want metrics timeouts of 500ms. got: %s, %s", defaults.MetricsReadTimeout, defaults.MetricsWriteTimeout)
+	}
+	if len(defaults.MetricsBuckets) != 0 {
+		t.Errorf("Want default buckets. got: %v", defaults.MetricsBuckets)
+	}
+
+	actual, err := New([]string{"fprocess=cat",
+		"metrics_port=9090",
+		"metrics_read_timeout=2s",
+		"metrics_write_timeout=3s",
+		"metrics_buckets=0.01, 0.1,1,10",
+		"metrics_function_labels=true",
+		"metrics_native_histograms=true",
+		"metrics_exemplars=true",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual.MetricsPort != 9090 {
+		t.Errorf("Want MetricsPort 9090. got: %d", actual.MetricsPort)
+	}
+	if actual.MetricsReadTimeout != 2*time.Second || actual.MetricsWriteTimeout != 3*time.Second {
+		t.Errorf("Want metrics timeouts of 2s and 3s. got: %s, %s", actual.MetricsReadTimeout, actual.MetricsWriteTimeout)
+	}
+	want := []float64{0.01, 0.1, 1, 10}
+	if len(actual.MetricsBuckets) != len(want) {
+		t.Fatalf("Want MetricsBuckets %v. got: %v", want, actual.MetricsBuckets)
+	}
+	for i := range want {
+		if actual.MetricsBuckets[i] != want[i] {
+			t.Errorf("Want MetricsBuckets %v. got: %v", want, actual.MetricsBuckets)
+		}
+	}
+	if !actual.MetricsFunctionLabels || !actual.MetricsNativeHistograms || !actual.MetricsExemplars {
+		t.Errorf("Want function labels, native histograms and exemplars enabled")
+	}
+
+	for _, buckets := range []string{"1,0.5", "0", "fast"} {
+		if _, err := New([]string{"fprocess=cat", "metrics_buckets=" + buckets}); err == nil {
+			t.Errorf("Want error for metrics_buckets=%s", buckets)
+		}
+	}
+}
+
+func Test_StatsD(t *testing.T) {
+	defaults, _ := New([]string{})
+	if len(defaults.StatsDAddress) != 0 {
+		t.Errorf("Want StatsD disabled by default. got: %s", defaults.StatsDAddress)
+	}
+	if defaults.StatsDPrefix != "custom" || defaults.StatsDMaxSeries != 1000 {
+		t.Errorf("Want StatsD prefix custom and 1000 series. got: %s, %d", defaults.StatsDPrefix, defaults.StatsDMaxSeries)
+	}
+
+	actual, err := New([]string{"fprocess=cat", "statsd_address=127.0.0.1:8125", "statsd_prefix=shop", "statsd_max_series=50"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual.StatsDAddress != "127.0.0.1:8125" || actual.StatsDPrefix != "shop" || actual.StatsDMaxSeries != 50 {
+		t.Errorf("Want StatsD on 127.0.0.1:8125 with prefix shop and 50 series. got: %s, %s, %d",
+			actual.StatsDAddress, actual.StatsDPrefix, actual.StatsDMaxSeries)
+	}
+
+	for _, env := range []string{
+		"statsd_address=0.0.0.0:8125",
+		"statsd_address=8125",
+		"statsd_prefix=my-shop",
+		"statsd_max_series=0",
+	} {
+		if _, err := New([]string{"fprocess=cat", env}); err == nil {
+			t.Errorf("Want error for %s", env)
+		}
+	}
+}
+
+func Test_ProcessMaxRSS(t *testing.T) {
+	defaults, _ := New([]string{})
+	if defaults.ProcessMaxRSS != 0 || defaults.ProcessCheckInterval != 5*time.Second {
+		t.Errorf("Want no max RSS, checked every 5s. got: %d, %s", defaults.ProcessMaxRSS, defaults.ProcessCheckInterval)
+	}
+
+	actual, err := New([]string{"fprocess=cat", "process_max_rss=536870912", "process_check_interval=1s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual.ProcessMaxRSS != 536870912 || actual.ProcessCheckInterval != time.Second {
+		t.Errorf("Want max RSS of 512MB, checked every 1s. got: %d, %s", actual.ProcessMaxRSS, actual.ProcessCheckInterval)
+	}
+
+	if _, err := New([]string{"fprocess=cat", "process_check_interval=0"}); err == nil {
+		t.Error("Want error for a process_check_interval of 0")
+	}
+}
+
+func Test_IdleTimeout(t *testing.T) {
+	defaults, _ := New([]string{})
+	if defaults.IdleTimeout != 0 || defaults.IdleExitCode != 0 || len(defaults.IdleHook) > 0 {
+		t.Errorf("Want idle shutdown disabled by default. got: %s, %d, %q", defaults.IdleTimeout, defaults.IdleExitCode, defaults.IdleHook)
+	}
+
+	actual, err := New([]string{"fprocess=cat", "idle_timeout=5m", "idle_exit_code=3", "idle_hook=/bin/notify idle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual.IdleTimeout != 5*time.Minute || actual.IdleExitCode != 3 || actual.IdleHook != "/bin/notify idle" {
+		t.Errorf("Want idle shutdown after 5m with exit code 3 and a hook. got: %s, %d, %q", actual.IdleTimeout, actual.IdleExitCode, actual.IdleHook)
+	}
+
+	if _, err := New([]string{"fprocess=cat", "idle_exit_code=256"}); err == nil {
+		t.Error("Want error for an idle_exit_code of 256")
+	}
+}
+
+func Test_ReadyInterval(t *testing.T) {
+	defaults, _ := New([]string{})
+	if defaults.ReadyInterval != 0 || defaults.ReadyTimeout != time.Second {
+		t.Errorf("Want ready_path probed on each request with a timeout of 1s. got: %s, %s", defaults.ReadyInterval, defaults.ReadyTimeout)
+	}
+	if defaults.ReadySuccessThreshold != 1 || defaults.ReadyFailureThreshold != 3 {
+		t.Errorf("Want thresholds of 1 and 3. got: %d, %d", defaults.ReadySuccessThreshold, defaults.ReadyFailureThreshold)
+	}
+
+	actual, err := New([]string{"fprocess=cat", "ready_interval=5s", "ready_timeout=2s", "ready_success_threshold=2", "ready_failure_threshold=5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual.ReadyInterval != 5*time.Second || actual.ReadyTimeout != 2*time.Second {
+		t.Errorf("Want interval of 5s and timeout of 2s. got: %s, %s", actual.ReadyInterval, actual.ReadyTimeout)
+	}
+	if actual.ReadySuccessThreshold != 2 || actual.ReadyFailureThreshold != 5 {
+		t.Errorf("Want thresholds of 2 and 5. got: %d, %d", actual.ReadySuccessThreshold, actual.ReadyFailureThreshold)
+	}
+
+	if _, err := New([]string{"fprocess=cat", "ready_failure_threshold=0"}); err == nil {
+		t.Error("Want error for a ready_failure_threshold of 0")
+	}
+}
+
+func Test_LockPath(t *testing.T) {
+	defaults, _ := New([]string{})
+	if defaults.LockPath != filepath.Join(os.TempDir(), ".lock") {
+		t.Errorf("Want lock file in the temporary directory. got: %s", defaults.LockPath)
+	}
+
+	actual, err := New([]string{"fprocess=cat", "lock_path=/run/watchdog/.lock"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual.LockPath != "/run/watchdog/.lock" {
+		t.Errorf("Want lock_path of /run/watchdog/.lock. got: %s", actual.LockPath)
+	}
+
+	if _, err := New([]string{"fprocess=cat", "lock_path=run/.lock"}); err == nil {
+		t.Error("Want error for a relative lock_path")
+	}
+}
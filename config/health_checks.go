@@ -0,0 +1,119 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	HealthCheckExec = "exec"
+	HealthCheckHTTP = "http"
+	HealthCheckTCP  = "tcp"
+	HealthCheckFile = "file"
+)
+
+// HealthCheck is a check run in the background on each Interval, and
+// reported by /_/ready, and also by /_/health when Liveness is set.
+type HealthCheck struct {
+	// Name identifies the check in the verbose report.
+	Name string `json:"name"`
+
+	// Type is one of exec, http, tcp or file.
+	Type string `json:"type"`
+
+	// Command is run for an exec check, which passes when it exits 0.
+	Command string `json:"command"`
+
+	// URL is requested with a GET for an http check, which passes on
+	// a 2xx or 3xx status.
+	URL string `json:"url"`
+
+	// Address is connected to for a tcp check, i.e. 127.0.0.1:5432.
+	Address string `json:"address"`
+
+	// Path must exist for a file check to pass.
+	Path string `json:"path"`
+
+	// Liveness adds the check to /_/health as well as /_/ready.
+	Liveness bool `json:"liveness"`
+
+	Interval time.Duration `json:"-"`
+	Timeout  time.Duration `json:"-"`
+}
+
+// loadHealthChecks reads a JSON array of checks from path, where the
+// interval and timeout are given as Go durations, i.e. "10s".
+func loadHealthChecks(path string) ([]HealthCheck, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read health_checks_file: %w", err)
+	}
+
+	var entries []struct {
+		HealthCheck
+		Interval string `json:"interval"`
+		Timeout  string `json:"timeout"`
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("unable to parse health_checks_file %s: %w", path, err)
+	}
+
+	checks := make([]HealthCheck, 0, len(entries))
+	names := map[string]bool{}
+	for i, entry := range entries {
+		check := entry.HealthCheck
+		if len(check.Name) == 0 {
+			return nil, fmt.Errorf("health check %d in %s has no name", i, path)
+		}
+		if names[check.Name] {
+			return nil, fmt.Errorf("health check %q in %s is defined more than once", check.Name, path)
+		}
+		names[check.Name] = true
+
+		var target string
+		switch check.Type {
+		case HealthCheckExec:
+			target = strings.TrimSpace(check.Command)
+		case HealthCheckHTTP:
+			target = check.URL
+		case HealthCheckTCP:
+			target = check.Address
+		case HealthCheckFile:
+			target = check.Path
+		default:
+			return nil, fmt.Errorf("health check %q in %s has an invalid type: %q, use %q, %q, %q or %q",
+				check.Name, path, check.Type, HealthCheckExec, HealthCheckHTTP, HealthCheckTCP, HealthCheckFile)
+		}
+		if len(target) == 0 {
+			return nil, fmt.Errorf("health check %q in %s has nothing to check for its type: %s", check.Name, path, check.Type)
+		}
+
+		check.Interval = 10 * time.Second
+		if len(entry.Interval) > 0 {
+			if check.Interval, err = time.ParseDuration(entry.Interval); err != nil {
+				return nil, fmt.Errorf("health check %q in %s has an invalid interval: %w", check.Name, path, err)
+			}
+		}
+
+		check.Timeout = time.Second
+		if len(entry.Timeout) > 0 {
+			if check.Timeout, err = time.ParseDuration(entry.Timeout); err != nil {
+				return nil, fmt.Errorf("health check %q in %s has an invalid timeout: %w", check.Name, path, err)
+			}
+		}
+
+		if check.Interval <= 0 || check.Timeout <= 0 || check.Timeout > check.Interval {
+			return nil, fmt.Errorf("health check %q in %s must have an interval and timeout over 0s, with the timeout no longer than the interval", check.Name, path)
+		}
+
+		checks = append(checks, check)
+	}
+
+	return checks, nil
+}
@@ -0,0 +1,64 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// LimitRule limits the requests which match all of PathPrefix, Methods
+// and Headers. Empty match fields match any request.
+type LimitRule struct {
+	// Name identifies the rule in rejections and metrics.
+	Name string `json:"name"`
+
+	PathPrefix string            `json:"path_prefix"`
+	Methods    []string          `json:"methods"`
+	Headers    map[string]string `json:"headers"`
+
+	// MaxInflight limits the matching requests in-flight, 0 for no limit.
+	MaxInflight int `json:"max_inflight"`
+
+	// Rate is the number of matching requests allowed per second,
+	// with up to Burst at once. 0 for no limit.
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// loadLimitRules reads a JSON array of rules from path. Rules are matched
+// in the order they are given.
+func loadLimitRules(path string) ([]LimitRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read limit_rules_file: %w", err)
+	}
+
+	var rules []LimitRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("unable to parse limit_rules_file %s: %w", path, err)
+	}
+
+	names := map[string]bool{}
+	for i, rule := range rules {
+		if len(rule.Name) == 0 {
+			return nil, fmt.Errorf("limit rule %d in %s has no name", i, path)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("limit rule %q in %s is defined more than once", rule.Name, path)
+		}
+		names[rule.Name] = true
+
+		if rule.MaxInflight < 0 || rule.Rate < 0 || rule.Burst < 0 {
+			return nil, fmt.Errorf("limit rule %q in %s must not have negative limits", rule.Name, path)
+		}
+
+		if rule.Rate > 0 && rule.Burst == 0 {
+			rules[i].Burst = 1
+		}
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,75 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// WarmupRequest is sent to the function before the watchdog is ready.
+type WarmupRequest struct {
+	// Method defaults to GET.
+	Method string `json:"method"`
+
+	// Path defaults to /.
+	Path string `json:"path"`
+
+	Headers map[string]string `json:"headers"`
+
+	// BodyFile is read into Body when the warm-up file is loaded.
+	BodyFile string `json:"body_file"`
+	Body     []byte `json:"-"`
+
+	// Status is the expected status code, which defaults to 200.
+	Status int `json:"status"`
+}
+
+// loadWarmup reads a JSON array of requests from path. The requests are
+// sent in the order they are given.
+func loadWarmup(path string) ([]WarmupRequest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read warmup_file: %w", err)
+	}
+
+	var requests []WarmupRequest
+	if err := json.Unmarshal(data, &requests); err != nil {
+		return nil, fmt.Errorf("unable to parse warmup_file %s: %w", path, err)
+	}
+
+	for i, req := range requests {
+		if len(req.Method) == 0 {
+			requests[i].Method = http.MethodGet
+		}
+		requests[i].Method = strings.ToUpper(requests[i].Method)
+
+		if len(req.Path) == 0 {
+			requests[i].Path = "/"
+		}
+		if !strings.HasPrefix(requests[i].Path, "/") {
+			return nil, fmt.Errorf("warm-up request %d in %s has an invalid path: %s, it must start with /", i, path, req.Path)
+		}
+
+		if req.Status == 0 {
+			requests[i].Status = http.StatusOK
+		}
+		if requests[i].Status < 100 || requests[i].Status > 599 {
+			return nil, fmt.Errorf("warm-up request %d in %s has an invalid status: %d", i, path, req.Status)
+		}
+
+		if len(req.BodyFile) > 0 {
+			body, err := os.ReadFile(req.BodyFile)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read body_file of warm-up request %d in %s: %w", i, path, err)
+			}
+			requests[i].Body = body
+		}
+	}
+
+	return requests, nil
+}
@@ -4,9 +4,16 @@
 package executor
 
 import (
+	"context"
+	"errors"
 	"io"
+	"time"
 )
 
+// errExecTimeout is the cause of a process being stopped by the exec
+// timeout.
+var errExecTimeout = errors.New("exec timeout")
+
 // FunctionRunner runs a function
 type FunctionRunner interface {
 	Run(f FunctionRequest) error
@@ -25,4 +32,37 @@ type FunctionRequest struct {
 	InputReader   io.ReadCloser
 	OutputWriter  io.Writer
 	ContentLength *int64
+
+	// CallID identifies the invocation in the function's logs.
+	CallID string
+
+	// Context carries the trace of the invocation, it is not used
+	// for cancellation unless StopWithContext is set.
+	Context context.Context
+
+	// StopWithContext stops the process once Context is done, such as
+	// for a readiness probe which has timed out. Invocations otherwise
+	// run until the exec timeout, even if the caller has gone away.
+	StopWithContext bool
+}
+
+// processContext returns the context to run the process of req with,
+// which is done after timeout, when it is over 0.
+func processContext(req FunctionRequest, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx := context.Background()
+	if req.StopWithContext && req.Context != nil {
+		ctx = req.Context
+	}
+
+	if timeout > 0 {
+		return context.WithTimeoutCause(ctx, timeout, errExecTimeout)
+	}
+
+	return context.WithCancel(ctx)
+}
+
+// execTimedOut returns true when ctx from processContext was done due
+// to the exec timeout.
+func execTimedOut(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errExecTimeout)
 }
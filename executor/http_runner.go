@@ -6,6 +6,7 @@ package executor
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 
@@ -18,11 +19,12 @@ import (
 	"os/exec"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
-	units "github.com/docker/go-units"
-	fhttputil "github.com/openfaas/faas-provider/httputil"
+	"github.com/openfaas/of-watchdog/metrics"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 // HTTPFunctionRunner creates and maintains one process responsible for handling all calls
@@ -40,53 +42,170 @@ type HTTPFunctionRunner struct {
 	BufferHTTPBody bool
 	LogPrefix      bool
 	LogBufferSize  int
-	LogCallId      bool
+	LogFormat      string            // LogFormat is LogFormatJSON, or text when empty
+	LogFields      map[string]string // LogFields are added to each JSON log line
+	LogOverflow    string            // LogOverflow is LogOverflowTruncate, or split when empty
+	LogMetrics     *metrics.Logs     // LogMetrics counts overlong log lines, when set
+	LogMultiline   *MultilineOptions // LogMultiline groups log lines into records, when set
+	LogLimiter     *LogLimiter       // LogLimiter drops log lines over the rate limit, when set
+	LogSink        io.Writer         // LogSink receives the function's logs as well as stdout and stderr, when set
 	ReverseProxy   *httputil.ReverseProxy
+	Metrics        *metrics.Function // Metrics counts timeouts and upstream errors, when set
+	Environment    []string          // Environment is added to the environment of the process
+
+	// mu guards Command and its pipes, which are replaced when the
+	// process is restarted.
+	mu         sync.Mutex
+	exited     chan struct{}
+	restarting *exec.Cmd
 }
 
 // Start forks the process used for processing incoming requests
 func (f *HTTPFunctionRunner) Start() error {
-	cmd := exec.Command(f.Process, f.ProcessArgs...)
+	f.Client = makeProxyClient(f.ExecTimeout)
 
-	var stdinErr error
-	var stdoutErr error
+	if f.ReverseProxy != nil {
+		errorHandler := f.ReverseProxy.ErrorHandler
+		f.ReverseProxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			if r.Context().Err() == nil {
+				f.upstreamError(err)
+			}
 
-	f.Command = cmd
-	f.StdinPipe, stdinErr = cmd.StdinPipe()
-	if stdinErr != nil {
-		return stdinErr
+			if errorHandler != nil {
+				errorHandler(w, r, err)
+			}
+		}
+	}
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGTERM)
+
+		<-sig
+
+		f.mu.Lock()
+		cmd := f.Command
+		f.mu.Unlock()
+
+		if cmd != nil && cmd.Process != nil {
+			cmd.Process.Signal(syscall.SIGTERM)
+		}
+	}()
+
+	return f.spawn()
+}
+
+// spawn starts the process, which must keep running unless it is
+// being restarted. When it can't be started, Running reports false.
+func (f *HTTPFunctionRunner) spawn() error {
+	cmd := exec.Command(f.Process, f.ProcessArgs...)
+	if len(f.Environment) > 0 {
+		cmd.Env = append(os.Environ(), f.Environment...)
+	}
+
+	stdinPipe, err := cmd.StdinPipe()
+	if err != nil {
+		return err
 	}
 
-	f.StdoutPipe, stdoutErr = cmd.StdoutPipe()
-	if stdoutErr != nil {
-		return stdoutErr
+	stdoutPipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
 	}
 
 	errPipe, _ := cmd.StderrPipe()
 
 	// Logs lines from stderr and stdout to the stderr and stdout of this process
-	bindLoggingPipe("stderr", errPipe, os.Stderr, f.LogPrefix, f.LogBufferSize)
-	bindLoggingPipe("stdout", f.StdoutPipe, os.Stdout, f.LogPrefix, f.LogBufferSize)
+	bindLoggingPipe("stderr", errPipe, os.Stderr, f.logOptions())
+	bindLoggingPipe("stdout", stdoutPipe, os.Stdout, f.logOptions())
 
-	f.Client = makeProxyClient(f.ExecTimeout)
-
-	go func() {
-		sig := make(chan os.Signal, 1)
-		signal.Notify(sig, syscall.SIGTERM)
+	startErr := cmd.Start()
 
-		<-sig
-		cmd.Process.Signal(syscall.SIGTERM)
-	}()
+	exited := make(chan struct{})
+	f.mu.Lock()
+	f.Command = cmd
+	f.StdinPipe = stdinPipe
+	f.StdoutPipe = stdoutPipe
+	f.exited = exited
+	f.mu.Unlock()
+
+	if startErr != nil {
+		close(exited)
+		return startErr
+	}
 
-	err := cmd.Start()
 	go func() {
 		err := cmd.Wait()
-		if err != nil {
+		close(exited)
+
+		f.mu.Lock()
+		restarting := f.restarting == cmd
+		f.mu.Unlock()
+
+		if err != nil && !restarting {
 			log.Fatalf("Forked function has terminated: %s", err.Error())
 		}
 	}()
 
-	return err
+	return nil
+}
+
+// Pid returns the process ID of the function, or 0 when it has not
+// been started.
+func (f *HTTPFunctionRunner) Pid() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Command == nil || f.Command.Process == nil {
+		return 0
+	}
+
+	return f.Command.Process.Pid
+}
+
+// Running returns true whilst the function process has been started and
+// has not exited.
+func (f *HTTPFunctionRunner) Running() bool {
+	f.mu.Lock()
+	exited := f.exited
+	f.mu.Unlock()
+
+	if exited == nil {
+		return false
+	}
+
+	select {
+	case <-exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// Restart stops the process with SIGTERM, or SIGKILL when it is still
+// running after grace, then starts it again. Requests made whilst the
+// process restarts fail. When the process can't be started again, the
+// error is returned and Running reports false, so that the watchdog's
+// health check fails rather than it exiting.
+func (f *HTTPFunctionRunner) Restart(grace time.Duration) error {
+	f.mu.Lock()
+	cmd := f.Command
+	exited := f.exited
+	f.restarting = cmd
+	f.mu.Unlock()
+
+	if cmd != nil && cmd.Process != nil {
+		cmd.Process.Signal(syscall.SIGTERM)
+
+		select {
+		case <-exited:
+		case <-time.After(grace):
+			cmd.Process.Kill()
+			<-exited
+		}
+	}
+
+	return f.spawn()
 }
 
 // Run a function with a long-running process with a HTTP protocol for communication
@@ -133,20 +252,26 @@ func (f *HTTPFunctionRunner) Run(req FunctionRequest, contentLength int64, r *ht
 	defer cancel()
 
 	if requiresStdlibProxy(r) {
-		ww := fhttputil.NewHttpWriteInterceptor(w)
-
-		f.ReverseProxy.ServeHTTP(w, r)
-		done := time.Since(startedTime)
+		spanCtx, span := StartSpan(r.Context(), "upstream")
+		upstream := r.Clone(spanCtx)
+		propagation.TraceContext{}.Inject(spanCtx, propagation.HeaderCarrier(upstream.Header))
 
-		log.Printf("%s %s - %d - Bytes: %s (%.4fs)", r.Method, r.RequestURI, ww.Status(), units.HumanSize(float64(ww.BytesWritten())), done.Seconds())
+		f.ReverseProxy.ServeHTTP(w, upstream)
+		span.End()
 	} else {
 
-		res, err := f.Client.Do(request.WithContext(reqCtx))
+		spanCtx, span := StartSpan(reqCtx, "upstream")
+		propagation.TraceContext{}.Inject(spanCtx, propagation.HeaderCarrier(request.Header))
+
+		res, err := f.Client.Do(request.WithContext(spanCtx))
+		span.End()
 		if err != nil {
 			log.Printf("Upstream HTTP request error: %s\n", err.Error())
 
 			// Error unrelated to context / deadline
 			if reqCtx.Err() == nil {
+				f.upstreamError(err)
+
 				w.Header().Set("X-Duration-Seconds", fmt.Sprintf("%f", time.Since(startedTime).Seconds()))
 				w.Header().Add("X-OpenFaaS-Internal", "of-watchdog")
 
@@ -158,6 +283,10 @@ func (f *HTTPFunctionRunner) Run(req FunctionRequest, contentLength int64, r *ht
 			<-reqCtx.Done()
 
 			if reqCtx.Err() != nil {
+				if reqCtx.Err() == context.DeadlineExceeded {
+					f.timedOut()
+				}
+
 				// Error due to timeout / deadline
 				log.Printf("Upstream HTTP killed due to exec_timeout: %s\n", f.ExecTimeout)
 				w.Header().Set("X-Duration-Seconds", fmt.Sprintf("%f", time.Since(startedTime).Seconds()))
@@ -183,28 +312,42 @@ func (f *HTTPFunctionRunner) Run(req FunctionRequest, contentLength int64, r *ht
 		if res.Body != nil {
 			defer res.Body.Close()
 
+			_, span := StartSpan(reqCtx, "copy response")
 			if _, err := io.Copy(w, res.Body); err != nil {
 				log.Printf("Error copying response body: %s", err)
 			}
+			span.End()
 		}
+	}
 
-		// Exclude logging for health check probes from the kubelet which can spam
-		// log collection systems.
-		if !strings.HasPrefix(r.UserAgent(), "kube-probe") {
-			if f.LogCallId {
-				callId := r.Header.Get("X-Call-Id")
-				if callId == "" {
-					callId = "none"
-				}
+	return nil
+}
 
-				log.Printf("%s %s - %s - ContentLength: %s (%.4fs) [%s]", r.Method, r.RequestURI, res.Status, units.HumanSize(float64(res.ContentLength)), done.Seconds(), callId)
-			} else {
-				log.Printf("%s %s - %s - ContentLength: %s (%.4fs)", r.Method, r.RequestURI, res.Status, units.HumanSize(float64(res.ContentLength)), done.Seconds())
-			}
-		}
+func (f *HTTPFunctionRunner) timedOut() {
+	if f.Metrics != nil {
+		f.Metrics.Timeouts.Inc()
 	}
+}
 
-	return nil
+func (f *HTTPFunctionRunner) upstreamError(err error) {
+	if f.Metrics != nil {
+		f.Metrics.UpstreamErrors.WithLabelValues(upstreamErrorReason(err)).Inc()
+	}
+}
+
+// upstreamErrorReason tells apart the ways in which the connection to
+// the function can fail, such as the process having exited.
+func upstreamErrorReason(err error) string {
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return "reset"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "closed"
+	default:
+		return "other"
+	}
 }
 
 func getTimeout(r *http.Request, defaultTimeout time.Duration) time.Duration {
@@ -260,3 +403,17 @@ func requiresStdlibProxy(req *http.Request) bool {
 		strings.Contains(acceptHeader, "application/x-ndjson") ||
 		req.Header.Get("Upgrade") == "websocket"
 }
+
+func (f *HTTPFunctionRunner) logOptions() logOptions {
+	return logOptions{
+		prefix:        f.LogPrefix,
+		maxBufferSize: f.LogBufferSize,
+		format:        f.LogFormat,
+		fields:        f.LogFields,
+		overflow:      f.LogOverflow,
+		metrics:       f.LogMetrics,
+		multiline:     f.LogMultiline,
+		limiter:       f.LogLimiter,
+		sink:          f.LogSink,
+	}
+}
@@ -128,3 +128,68 @@ func Test_requiresStdlibProxy(t *testing.T) {
 		})
 	}
 }
+
+func TestHTTPRunner_Restart(t *testing.T) {
+	f := HTTPFunctionRunner{
+		Process:       "sleep",
+		ProcessArgs:   []string{"30"},
+		LogBufferSize: 1024,
+	}
+
+	if err := f.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	before := f.Pid()
+	if before <= 0 {
+		t.Fatalf("want a pid once started, got: %d", before)
+	}
+
+	if err := f.Restart(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	after := f.Pid()
+	if after <= 0 || after == before {
+		t.Errorf("want a new pid after restart, got: %d, was: %d", after, before)
+	}
+	if !f.Running() {
+		t.Error("want the process to be running after restart")
+	}
+
+	// Stop the process without it being treated as a crash.
+	f.mu.Lock()
+	f.restarting = f.Command
+	exited := f.exited
+	f.mu.Unlock()
+	f.Command.Process.Kill()
+
+	<-exited
+	if f.Running() {
+		t.Error("want the process not to be running once it has exited")
+	}
+}
+
+func TestHTTPRunner_RestartFailure(t *testing.T) {
+	f := HTTPFunctionRunner{
+		Process:       "sleep",
+		ProcessArgs:   []string{"30"},
+		LogBufferSize: 1024,
+	}
+
+	if err := f.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	f.Process = "/does/not/exist"
+	if err := f.Restart(time.Second); err == nil {
+		t.Fatal("want an error when the process can't be started again")
+	}
+
+	if f.Running() {
+		t.Error("want the process not to be running after a failed restart")
+	}
+	if got := f.Pid(); got != 0 {
+		t.Errorf("want no pid after a failed restart, got: %d", got)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openfaas/of-watchdog/metrics"
+	"github.com/openfaas/of-watchdog/ratelimit"
+)
+
+// LogLimitOptions limit the rate of lines logged from each of the
+// function's streams, so that a flood of logs is not passed on.
+type LogLimitOptions struct {
+	// Rate is the lines per second allowed for each stream, with
+	// up to Burst at once.
+	Rate  float64
+	Burst int
+
+	// Sample keeps one in every Sample lines over the rate,
+	// or none when 0.
+	Sample int
+
+	// ErrorPattern matches lines which are always kept. Lines which
+	// are JSON with an error "level" are also always kept.
+	ErrorPattern *regexp.Regexp
+
+	// SummaryInterval is how often the number of suppressed
+	// lines is logged.
+	SummaryInterval time.Duration
+}
+
+// LogLimiter applies LogLimitOptions to each stream, across all of the
+// invocations of the function.
+type LogLimiter struct {
+	opts    LogLimitOptions
+	metrics *metrics.Logs
+	now     func() time.Time
+
+	mu      sync.Mutex
+	streams map[string]*limitedStream
+}
+
+type limitedStream struct {
+	bucket *ratelimit.Bucket
+
+	// over counts the lines over the rate, for sampling, and
+	// suppressed those dropped since the last summary.
+	over       int
+	suppressed int
+}
+
+// NewLogLimiter creates a limiter, now defaults to time.Now when nil.
+func NewLogLimiter(opts LogLimitOptions, logMetrics *metrics.Logs, now func() time.Time) *LogLimiter {
+	if now == nil {
+		now = time.Now
+	}
+
+	return &LogLimiter{
+		opts:    opts,
+		metrics: logMetrics,
+		now:     now,
+		streams: map[string]*limitedStream{},
+	}
+}
+
+// Allow returns true when line from stream should be logged.
+func (l *LogLimiter) Allow(stream, line string) bool {
+	if l.isError(line) {
+		return true
+	}
+
+	l.mu.Lock()
+	s, ok := l.streams[stream]
+	if !ok {
+		s = &limitedStream{bucket: ratelimit.NewBucket(l.opts.Rate, l.opts.Burst, l.now)}
+		l.streams[stream] = s
+	}
+	l.mu.Unlock()
+
+	if _, ok := s.bucket.Take(); ok {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s.over++
+	if l.opts.Sample > 0 && s.over%l.opts.Sample == 0 {
+		return true
+	}
+
+	s.suppressed++
+	if l.metrics != nil {
+		l.metrics.LinesDropped.WithLabelValues(stream).Inc()
+	}
+
+	return false
+}
+
+// Run logs a summary of the suppressed lines on each interval
+// until ctx is cancelled.
+func (l *LogLimiter) Run(ctx context.Context) {
+	ticker := time.NewTicker(l.opts.SummaryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			l.summarize()
+			return
+		case <-ticker.C:
+			l.summarize()
+		}
+	}
+}
+
+func (l *LogLimiter) summarize() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for name, s := range l.streams {
+		if s.suppressed > 0 {
+			log.Printf("Log rate limit: %d lines suppressed from the function's %s in the last %s", s.suppressed, name, l.opts.SummaryInterval)
+			s.suppressed = 0
+		}
+	}
+}
+
+// isError returns true for lines which match the error pattern, or
+// which are JSON with an error level.
+func (l *LogLimiter) isError(line string) bool {
+	if l.opts.ErrorPattern != nil && l.opts.ErrorPattern.MatchString(line) {
+		return true
+	}
+
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "{") {
+		return false
+	}
+
+	entry := struct {
+		Level string `json:"level"`
+	}{}
+	if err := json.Unmarshal([]byte(trimmed), &entry); err != nil {
+		return false
+	}
+
+	switch strings.ToLower(entry.Level) {
+	case "error", "fatal", "critical", "panic":
+		return true
+	default:
+		return false
+	}
+}
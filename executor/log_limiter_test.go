@@ -0,0 +1,115 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package executor
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openfaas/of-watchdog/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLogLimiter_DropsOverRateAndKeepsErrors(t *testing.T) {
+	now := time.Unix(0, 0)
+	logMetrics := metrics.NewLogs(prometheus.NewRegistry())
+
+	l := NewLogLimiter(LogLimitOptions{
+		Rate:         1,
+		Burst:        2,
+		ErrorPattern: regexp.MustCompile(`(?i)\berror\b`),
+	}, logMetrics, func() time.Time { return now })
+
+	var kept []string
+	for _, line := range []string{
+		"one",
+		"two",
+		"three",
+		"ERROR: disk full",
+		`{"level":"error","msg":"failed"}`,
+		`{"level":"info","msg":"ok"}`,
+	} {
+		if l.Allow("stderr", line) {
+			kept = append(kept, line)
+		}
+	}
+
+	want := []string{"one", "two", "ERROR: disk full", `{"level":"error","msg":"failed"}`}
+	if strings.Join(kept, "|") != strings.Join(want, "|") {
+		t.Errorf("want lines kept %q, got %q", want, kept)
+	}
+
+	if got := testutil.ToFloat64(logMetrics.LinesDropped.WithLabelValues("stderr")); got != 2 {
+		t.Errorf("want 2 lines dropped, got: %f", got)
+	}
+
+	// Each stream has its own bucket.
+	if !l.Allow("stdout", "one") {
+		t.Error("want stdout to be limited separately")
+	}
+
+	now = now.Add(time.Second)
+	if !l.Allow("stderr", "four") {
+		t.Error("want a line allowed once the bucket refills")
+	}
+}
+
+func TestLogLimiter_SamplesOverRate(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := NewLogLimiter(LogLimitOptions{
+		Rate:   1,
+		Burst:  1,
+		Sample: 3,
+	}, nil, func() time.Time { return now })
+
+	kept := 0
+	for i := 0; i < 10; i++ {
+		if l.Allow("stderr", "flood") {
+			kept++
+		}
+	}
+
+	// The first line is within the burst, then 1 in 3 of the other 9.
+	if kept != 4 {
+		t.Errorf("want 4 lines kept, got: %d", kept)
+	}
+}
+
+func TestLogLimiter_SummarizesSuppressedLines(t *testing.T) {
+	logs := bytes.Buffer{}
+
+	log.SetOutput(&logs)
+	defer func() {
+		log.SetOutput(os.Stderr)
+	}()
+
+	now := time.Unix(0, 0)
+	l := NewLogLimiter(LogLimitOptions{
+		Rate:            1,
+		Burst:           1,
+		SummaryInterval: 10 * time.Second,
+	}, nil, func() time.Time { return now })
+
+	for i := 0; i < 5; i++ {
+		l.Allow("stderr", "flood")
+	}
+	l.summarize()
+
+	want := "4 lines suppressed from the function's stderr in the last 10s"
+	if !strings.Contains(logs.String(), want) {
+		t.Errorf("want summary %q, got: %q", want, logs.String())
+	}
+
+	logs.Reset()
+	l.summarize()
+	if logs.Len() > 0 {
+		t.Errorf("want no summary when no lines were suppressed, got: %q", logs.String())
+	}
+}
@@ -5,48 +5,171 @@ package executor
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"io"
 	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/openfaas/of-watchdog/logsink"
+	"github.com/openfaas/of-watchdog/metrics"
+)
+
+const (
+	// LogFormatJSON writes each line from the function as a JSON object.
+	LogFormatJSON = "json"
+
+	// LogOverflowTruncate cuts lines longer than the buffer short,
+	// instead of writing them in several parts.
+	LogOverflowTruncate = "truncate"
+)
+
+const (
+	// truncatedMarker ends a line which was cut short.
+	truncatedMarker = " [truncated]"
+
+	// continuedMarker ends each part of a split line but the last.
+	continuedMarker = " [continued]"
 )
 
+// logOptions control how lines read from a function's pipes are written.
+type logOptions struct {
+	// prefix adds the date, time and stream name to text lines.
+	prefix bool
+
+	// maxBufferSize is the longest line which can be read, or
+	// unbuffered when negative.
+	maxBufferSize int
+
+	// format is LogFormatJSON, or text when empty.
+	format string
+
+	// fields are added to each JSON line, such as the function name.
+	fields map[string]string
+
+	// callID is added to each JSON line as call_id, and to the end of
+	// each text line in brackets when tagCallID is set.
+	callID    string
+	tagCallID bool
+
+	// overflow is LogOverflowTruncate, or split when empty.
+	overflow string
+
+	// metrics counts lines which are split or truncated, when set.
+	metrics *metrics.Logs
+
+	// multiline groups lines into records, when set.
+	multiline *MultilineOptions
+
+	// limiter drops lines over the rate limit, when set.
+	limiter *LogLimiter
+
+	// sink is written to as well as the output, when set.
+	sink io.Writer
+}
+
 // bindLoggingPipe spawns a goroutine for passing through logging of the given output pipe.
-func bindLoggingPipe(name string, pipe io.Reader, output io.Writer, logPrefix bool, maxBufferSize int) {
+// The returned channel is closed once the pipe is drained and the last line is written.
+func bindLoggingPipe(name string, pipe io.Reader, output io.Writer, opts logOptions) <-chan struct{} {
 	log.Printf("Started logging: %s from function.", name)
 
+	if opts.sink != nil {
+		output = logsink.Tee(output, opts.sink)
+	}
+
 	logFlags := log.Flags()
 	prefix := log.Prefix()
-	if logPrefix == false {
+	if opts.prefix == false {
 		logFlags = 0
 		prefix = "" // Unnecessary, but set explicitly for completeness.
 	}
 
-	logger := log.New(output, prefix, logFlags)
+	l := &lineLogger{
+		name:   name,
+		output: output,
+		logger: log.New(output, prefix, logFlags),
+		opts:   opts,
+	}
 
-	if maxBufferSize >= 0 {
-		go pipeBuffered(name, pipe, logger, logPrefix, maxBufferSize)
-	} else {
-		go pipeUnbuffered(name, pipe, logger, logPrefix)
+	if opts.multiline != nil {
+		l.records = newMultilineBuffer(*opts.multiline, l.Log)
 	}
-}
 
-func pipeBuffered(name string, pipe io.Reader, logger *log.Logger, logPrefix bool, maxBufferSize int) {
-	buf := make([]byte, maxBufferSize)
-	scanner := bufio.NewScanner(pipe)
-	scanner.Buffer(buf, maxBufferSize)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
 
-	for scanner.Scan() {
-		if logPrefix {
-			logger.Printf("%s: %s", name, scanner.Text())
+		if opts.maxBufferSize >= 0 {
+			pipeBuffered(name, pipe, l, opts.maxBufferSize)
 		} else {
-			logger.Print(scanner.Text())
+			pipeUnbuffered(name, pipe, l)
+		}
+	}()
+
+	return done
+}
+
+// pipeBuffered reads lines of up to maxBufferSize bytes. Longer lines are
+// split or truncated, so that the pipe is always drained and the function
+// never blocks on writing to it.
+func pipeBuffered(name string, pipe io.Reader, l *lineLogger, maxBufferSize int) {
+	defer l.Close()
+
+	r := bufio.NewReaderSize(pipe, maxBufferSize)
+
+	// discarding is set after a line is truncated, until its end is read,
+	// and continued is set whilst a line is being split.
+	discarding := false
+	continued := false
+
+	for {
+		chunk, err := r.ReadSlice('\n')
+
+		if err == bufio.ErrBufferFull {
+			if discarding {
+				l.dropped(len(chunk))
+				continue
+			}
+
+			if l.opts.overflow == LogOverflowTruncate {
+				l.Write(string(chunk) + truncatedMarker)
+				l.truncated()
+				discarding = true
+			} else {
+				l.Write(string(chunk) + continuedMarker)
+				if !continued {
+					l.split()
+				}
+				continued = true
+			}
+			continue
+		}
+
+		continued = false
+
+		if len(chunk) > 0 {
+			if discarding {
+				l.dropped(len(chunk))
+				discarding = false
+			} else {
+				line := strings.TrimSuffix(string(chunk), "\n")
+				l.Write(strings.TrimSuffix(line, "\r"))
+			}
+		}
+
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Error reading %s: %s", name, err)
+			}
+			break
 		}
-	}
-	if err := scanner.Err(); err != nil {
-		log.Printf("Error reading %s: %s", name, err)
 	}
 }
 
-func pipeUnbuffered(name string, pipe io.Reader, logger *log.Logger, logPrefix bool) {
+func pipeUnbuffered(name string, pipe io.Reader, l *lineLogger) {
+	defer l.Close()
 
 	r := bufio.NewReader(pipe)
 
@@ -58,11 +181,161 @@ func pipeUnbuffered(name string, pipe io.Reader, logger *log.Logger, logPrefix b
 			}
 			break
 		}
-		if logPrefix {
-			logger.Printf("%s: %s", name, line)
-		} else {
-			logger.Print(line)
+		l.Write(line)
+	}
+
+}
+
+// lineLogger writes each line read from a pipe in the configured format.
+type lineLogger struct {
+	name   string
+	output io.Writer
+	logger *log.Logger
+	opts   logOptions
+
+	records *multilineBuffer
+}
+
+// Write logs a line read from the pipe, or adds it to the current
+// record in multiline mode.
+func (l *lineLogger) Write(line string) {
+	if l.records != nil {
+		l.records.Add(line)
+		return
+	}
+
+	l.Log(line)
+}
+
+// Close writes any record which is incomplete when the pipe closes.
+func (l *lineLogger) Close() {
+	if l.records != nil {
+		l.records.Close()
+	}
+}
+
+// Log writes a line or record in the configured format, unless it is
+// over the rate limit.
+func (l *lineLogger) Log(line string) {
+	if l.opts.limiter != nil && !l.opts.limiter.Allow(l.name, line) {
+		return
+	}
+
+	if l.opts.format == LogFormatJSON {
+		l.output.Write(l.formatJSON(strings.TrimRight(line, "\r\n")))
+		return
+	}
+
+	// A multiline record is written on one line, so that it is still
+	// one record to a log backend which reads the output line by line.
+	if trimmed := strings.TrimRight(line, "\r\n"); strings.Contains(trimmed, "\n") {
+		line = strings.ReplaceAll(trimmed, "\n", `\n`)
+	}
+
+	if l.opts.tagCallID && len(l.opts.callID) > 0 {
+		line = strings.TrimRight(line, "\r\n") + " [" + l.opts.callID + "]"
+	}
+
+	if l.opts.prefix {
+		l.logger.Printf("%s: %s", l.name, line)
+	} else {
+		l.logger.Print(line)
+	}
+}
+
+func (l *lineLogger) split() {
+	if l.opts.metrics != nil {
+		l.opts.metrics.LinesSplit.WithLabelValues(l.name).Inc()
+	}
+}
+
+func (l *lineLogger) truncated() {
+	if l.opts.metrics != nil {
+		l.opts.metrics.LinesTruncated.WithLabelValues(l.name).Inc()
+	}
+}
+
+func (l *lineLogger) dropped(n int) {
+	if l.opts.metrics != nil {
+		l.opts.metrics.BytesDropped.WithLabelValues(l.name).Add(float64(n))
+	}
+}
+
+// formatJSON merges the stream name and fields into a line which is
+// already a JSON object, without replacing any of its own keys. Any
+// other line is wrapped in an object as "msg". The result is always
+// a single line.
+func (l *lineLogger) formatJSON(line string) []byte {
+	out := &bytes.Buffer{}
+
+	existing := map[string]json.RawMessage{}
+	trimmed := strings.TrimSpace(line)
+	if strings.HasPrefix(trimmed, "{") && json.Unmarshal([]byte(trimmed), &existing) == nil {
+		// Compacting removes any newlines from an object which was
+		// grouped from several lines in multiline mode.
+		compact := &bytes.Buffer{}
+		json.Compact(compact, []byte(trimmed))
+		out.WriteString(strings.TrimSuffix(compact.String(), "}"))
+
+		first := len(existing) == 0
+		for _, kv := range l.jsonFields(existing) {
+			if !first {
+				out.WriteByte(',')
+			}
+			first = false
+			writeJSONField(out, kv[0], kv[1])
+		}
+
+		out.WriteString("}\n")
+		return out.Bytes()
+	}
+
+	out.WriteByte('{')
+	writeJSONField(out, "time", time.Now().UTC().Format(time.RFC3339Nano))
+	for _, kv := range l.jsonFields(existing) {
+		out.WriteByte(',')
+		writeJSONField(out, kv[0], kv[1])
+	}
+	out.WriteByte(',')
+	writeJSONField(out, "msg", line)
+	out.WriteString("}\n")
+
+	return out.Bytes()
+}
+
+// jsonFields returns the stream and fields which are not in existing,
+// in a stable order.
+func (l *lineLogger) jsonFields(existing map[string]json.RawMessage) [][2]string {
+	var fields [][2]string
+
+	if _, ok := existing["stream"]; !ok {
+		fields = append(fields, [2]string{"stream", l.name})
+	}
+
+	keys := make([]string, 0, len(l.opts.fields))
+	for key := range l.opts.fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if _, ok := existing[key]; !ok {
+			fields = append(fields, [2]string{key, l.opts.fields[key]})
 		}
 	}
 
+	if _, ok := existing["call_id"]; !ok && len(l.opts.callID) > 0 {
+		fields = append(fields, [2]string{"call_id", l.opts.callID})
+	}
+
+	return fields
+}
+
+func writeJSONField(out *bytes.Buffer, key, value string) {
+	k, _ := json.Marshal(key)
+	v, _ := json.Marshal(value)
+
+	out.Write(k)
+	out.WriteByte(':')
+	out.Write(v)
 }
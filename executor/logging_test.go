@@ -5,18 +5,23 @@ package executor
 
 import (
 	"bytes"
+	"encoding/json"
 	"log"
 	"math"
 	"os"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/openfaas/of-watchdog/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
-func TestBindLoggingPipe_ErrorsWithLargeToken(t *testing.T) {
+func TestBindLoggingPipe_SplitsLargeToken(t *testing.T) {
 	input := `Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt ut labore et dolore magna aliqua. Ut enim ad minim veniam, quis nostrud exercitation ullamco laboris nisi ut aliquip ex ea commodo consequat. Duis aute irure dolor in reprehenderit in voluptate velit esse cillum dolore eu fugiat nulla pariatur. Excepteur sint occaecat cupidatat non proident, sunt in culpa qui officia deserunt mollit anim id est laborum.`
 
-	reader := strings.NewReader(input)
+	reader := strings.NewReader(input + "\nnext line\n")
 
 	logs := bytes.Buffer{}
 
@@ -27,22 +32,58 @@ func TestBindLoggingPipe_ErrorsWithLargeToken(t *testing.T) {
 
 	out := bytes.Buffer{}
 
+	logMetrics := metrics.NewLogs(prometheus.NewRegistry())
 	maxBufferBytes := 32
 	addPrefix := false
-	bindLoggingPipe("TestFunc", reader, &out, addPrefix, maxBufferBytes)
-
-	// give the pipe time to actually parse the logs
-	time.Sleep(500 * time.Millisecond)
+	<-bindLoggingPipe("TestFunc", reader, &out, logOptions{prefix: addPrefix, maxBufferSize: maxBufferBytes, metrics: logMetrics})
 
-	got := out.String()
 	want := ""
-	if want != got {
-		t.Fatalf("expected empty string due to error, but got %q", got)
+	rest := input
+	for len(rest) > maxBufferBytes {
+		want += rest[:maxBufferBytes] + continuedMarker + "\n"
+		rest = rest[maxBufferBytes:]
+	}
+	want += rest + "\nnext line\n"
+
+	if got := out.String(); want != got {
+		t.Fatalf("want output %q, but got %q", want, got)
 	}
 
 	wantSt := `bufio.Scanner: token too long`
-	if !strings.Contains(logs.String(), wantSt) {
-		t.Fatalf("want text: %q, but not found in: %q", wantSt, logs.String())
+	if strings.Contains(logs.String(), wantSt) {
+		t.Fatalf("Found error %s in output: %q", wantSt, logs.String())
+	}
+
+	if got := testutil.ToFloat64(logMetrics.LinesSplit.WithLabelValues("TestFunc")); got != 1 {
+		t.Errorf("want 1 line split, got: %f", got)
+	}
+}
+
+func TestBindLoggingPipe_TruncatesLargeToken(t *testing.T) {
+	input := "0123456789abcdefghijklmnopqrstuvwxyz0123456789\nnext line\n"
+
+	out := bytes.Buffer{}
+
+	logMetrics := metrics.NewLogs(prometheus.NewRegistry())
+	opts := logOptions{
+		maxBufferSize: 16,
+		overflow:      LogOverflowTruncate,
+		metrics:       logMetrics,
+	}
+	<-bindLoggingPipe("stderr", strings.NewReader(input), &out, opts)
+
+	want := "0123456789abcdef" + truncatedMarker + "\nnext line\n"
+	if got := out.String(); want != got {
+		t.Fatalf("want output %q, but got %q", want, got)
+	}
+
+	if got := testutil.ToFloat64(logMetrics.LinesTruncated.WithLabelValues("stderr")); got != 1 {
+		t.Errorf("want 1 line truncated, got: %f", got)
+	}
+
+	// The newline which ends the truncated line is dropped with it.
+	if got := testutil.ToFloat64(logMetrics.BytesDropped.WithLabelValues("stderr")); got != 31 {
+		t.Errorf("want 31 bytes dropped, got: %f", got)
 	}
 }
 
@@ -63,10 +104,7 @@ func TestBindLoggingPipe_ReadsValidSize(t *testing.T) {
 
 	maxBufferBytes := validSize
 	addPrefix := false
-	bindLoggingPipe("TestFunc", reader, &out, addPrefix, maxBufferBytes)
-
-	// give the pipe time to actually parse the logs
-	time.Sleep(500 * time.Millisecond)
+	<-bindLoggingPipe("TestFunc", reader, &out, logOptions{prefix: addPrefix, maxBufferSize: maxBufferBytes})
 
 	got := out.String()
 	want := input
@@ -100,10 +138,7 @@ func TestBindLoggingPipe_ReadsValidSizedLines(t *testing.T) {
 
 	maxBufferBytes := validSize
 	addPrefix := false
-	bindLoggingPipe("TestFunc", reader, &out, addPrefix, maxBufferBytes)
-
-	// give the pipe time to actually parse the logs
-	time.Sleep(500 * time.Millisecond)
+	<-bindLoggingPipe("TestFunc", reader, &out, logOptions{prefix: addPrefix, maxBufferSize: maxBufferBytes})
 
 	got := out.String()
 	want := input1 + input2
@@ -117,3 +152,94 @@ func TestBindLoggingPipe_ReadsValidSizedLines(t *testing.T) {
 		t.Fatalf("Found error %s in output: %q", wantSt, logs.String())
 	}
 }
+
+func TestBindLoggingPipe_JSONMergesFieldsIntoJSONLines(t *testing.T) {
+	input := `{"level":"info","msg":"started"}
+{"msg":"from function","function":"overridden"}
+`
+	out := bytes.Buffer{}
+	opts := logOptions{
+		maxBufferSize: 1024,
+		format:        LogFormatJSON,
+		fields:        map[string]string{"function": "figlet", "namespace": "openfaas-fn"},
+	}
+	<-bindLoggingPipe("stderr", strings.NewReader(input), &out, opts)
+
+	want := `{"level":"info","msg":"started","stream":"stderr","function":"figlet","namespace":"openfaas-fn"}
+{"msg":"from function","function":"overridden","stream":"stderr","namespace":"openfaas-fn"}
+`
+	if got := out.String(); got != want {
+		t.Fatalf("want output %q, but got %q", want, got)
+	}
+}
+
+func TestBindLoggingPipe_JSONWrapsPlainLines(t *testing.T) {
+	input := "plain text from the function\n"
+
+	out := bytes.Buffer{}
+	opts := logOptions{
+		prefix:        true,
+		maxBufferSize: -1,
+		format:        LogFormatJSON,
+		fields:        map[string]string{"function": "figlet"},
+	}
+	<-bindLoggingPipe("stdout", strings.NewReader(input), &out, opts)
+
+	line := map[string]string{}
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("want a JSON line, got %q, error: %s", out.String(), err)
+	}
+
+	if line["msg"] != "plain text from the function" || line["stream"] != "stdout" || line["function"] != "figlet" {
+		t.Errorf("want msg, stream and function fields, got: %v", line)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, line["time"]); err != nil {
+		t.Errorf("want RFC3339 time field, got: %q", line["time"])
+	}
+}
+
+func TestBindLoggingPipe_TagsTextLinesWithCallID(t *testing.T) {
+	input := "first\nsecond\n"
+
+	out := bytes.Buffer{}
+	opts := logOptions{
+		maxBufferSize: 1024,
+		callID:        "079d9ff9",
+		tagCallID:     true,
+	}
+	<-bindLoggingPipe("stderr", strings.NewReader(input), &out, opts)
+
+	want := "first [079d9ff9]\nsecond [079d9ff9]\n"
+	if got := out.String(); got != want {
+		t.Fatalf("want output %q, but got %q", want, got)
+	}
+}
+
+func TestBindLoggingPipe_JSONAddsCallID(t *testing.T) {
+	input := `{"msg":"hello"}
+`
+	out := bytes.Buffer{}
+	opts := logOptions{
+		maxBufferSize: 1024,
+		format:        LogFormatJSON,
+		callID:        "079d9ff9",
+	}
+	<-bindLoggingPipe("stderr", strings.NewReader(input), &out, opts)
+
+	want := `{"msg":"hello","stream":"stderr","call_id":"079d9ff9"}
+`
+	if got := out.String(); got != want {
+		t.Fatalf("want output %q, but got %q", want, got)
+	}
+}
+
+func TestBindLoggingPipe_WritesToSink(t *testing.T) {
+	out := bytes.Buffer{}
+	sink := bytes.Buffer{}
+
+	<-bindLoggingPipe("stderr", strings.NewReader("to both\n"), &out, logOptions{maxBufferSize: 1024, sink: &sink})
+
+	if out.String() != "to both\n" || sink.String() != "to both\n" {
+		t.Errorf("want the line in the output and the sink, got: %q and %q", out.String(), sink.String())
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package executor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/openfaas/of-watchdog/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestStreamingRunner_RecordsSpawnAndTimeout(t *testing.T) {
+	fnMetrics := metrics.NewFunction(prometheus.NewRegistry())
+	f := StreamingFunctionRunner{
+		ExecTimeout:   100 * time.Millisecond,
+		LogBufferSize: 1024,
+		Metrics:       fnMetrics,
+	}
+
+	err := f.Run(FunctionRequest{
+		Process:      "sleep",
+		ProcessArgs:  []string{"5"},
+		OutputWriter: &bytes.Buffer{},
+	})
+	if err == nil {
+		t.Fatal("want an error for a process stopped by the exec timeout")
+	}
+
+	if got := testutil.ToFloat64(fnMetrics.Timeouts); got != 1 {
+		t.Errorf("want 1 timeout, got: %f", got)
+	}
+	if got := testutil.CollectAndCount(fnMetrics.SpawnDuration); got != 1 {
+		t.Errorf("want spawn duration recorded, got: %d series", got)
+	}
+}
+
+func TestStreamingRunner_StopWithContext(t *testing.T) {
+	fnMetrics := metrics.NewFunction(prometheus.NewRegistry())
+	f := StreamingFunctionRunner{
+		ExecTimeout:   5 * time.Second,
+		LogBufferSize: 1024,
+		Metrics:       fnMetrics,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := f.Run(FunctionRequest{
+		Process:         "sleep",
+		ProcessArgs:     []string{"5"},
+		OutputWriter:    &bytes.Buffer{},
+		Context:         ctx,
+		StopWithContext: true,
+	})
+	if err == nil {
+		t.Fatal("want an error for a process stopped with its context")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("want the process stopped with its context, took: %s", elapsed)
+	}
+
+	if got := testutil.ToFloat64(fnMetrics.Timeouts); got != 0 {
+		t.Errorf("want no exec timeouts counted, got: %f", got)
+	}
+}
+
+func TestHTTPRunner_CountsRefusedUpstream(t *testing.T) {
+	// Find a free port, then close it so that the connection is refused.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream, _ := url.Parse(fmt.Sprintf("http://%s", l.Addr()))
+	l.Close()
+
+	fnMetrics := metrics.NewFunction(prometheus.NewRegistry())
+	f := HTTPFunctionRunner{
+		ExecTimeout: time.Second,
+		UpstreamURL: upstream,
+		Client:      makeProxyClient(time.Second),
+		Metrics:     fnMetrics,
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RequestURI = ""
+	if err := f.Run(FunctionRequest{}, 0, r, w); err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("want status: %d, got: %d", http.StatusInternalServerError, w.Code)
+	}
+	if got := testutil.ToFloat64(fnMetrics.UpstreamErrors.WithLabelValues("refused")); got != 1 {
+		t.Errorf("want 1 refused upstream error, got: %f", got)
+	}
+}
+
+func TestUpstreamErrorReason(t *testing.T) {
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, want: "refused"},
+		{err: &url.Error{Op: "Get", Err: syscall.ECONNRESET}, want: "reset"},
+		{err: fmt.Errorf("read: %w", syscall.EPIPE), want: "reset"},
+		{err: &url.Error{Op: "Get", Err: io.EOF}, want: "closed"},
+		{err: &url.Error{Op: "Get", Err: fmt.Errorf("unexpected: %w", net.ErrClosed)}, want: "other"},
+	}
+
+	for _, tc := range cases {
+		if got := upstreamErrorReason(tc.err); got != tc.want {
+			t.Errorf("%v: want reason: %s, got: %s", tc.err, tc.want, got)
+		}
+	}
+}
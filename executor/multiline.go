@@ -0,0 +1,115 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package executor
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MultilineOptions group lines from the function into one log record,
+// such as the lines of a stack trace.
+type MultilineOptions struct {
+	// Start matches the first line of a record, any line which does
+	// not match continues the record. When nil, only Continue is used.
+	Start *regexp.Regexp
+
+	// Continue matches a line which continues the record, such as
+	// one which is indented.
+	Continue *regexp.Regexp
+
+	// Timeout is how long to wait for another line before the
+	// record is written.
+	Timeout time.Duration
+
+	// MaxBytes is the largest record, a line which would make the
+	// record any larger starts a new one.
+	MaxBytes int
+}
+
+// multilineBuffer collects lines into records and passes each
+// complete record to emit.
+type multilineBuffer struct {
+	opts MultilineOptions
+	emit func(string)
+
+	mu    sync.Mutex
+	lines []string
+	size  int
+	timer *time.Timer
+}
+
+func newMultilineBuffer(opts MultilineOptions, emit func(string)) *multilineBuffer {
+	return &multilineBuffer{
+		opts: opts,
+		emit: emit,
+	}
+}
+
+// Add appends line to the current record, or writes the current
+// record and starts a new one with line.
+func (m *multilineBuffer) Add(line string) {
+	line = strings.TrimRight(line, "\r\n")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.lines) > 0 && (!m.continues(line) || m.size+1+len(line) > m.opts.MaxBytes) {
+		m.flush()
+	}
+
+	if len(m.lines) > 0 {
+		m.size++
+	}
+	m.lines = append(m.lines, line)
+	m.size += len(line)
+
+	if m.timer == nil {
+		m.timer = time.AfterFunc(m.opts.Timeout, m.Flush)
+	} else {
+		m.timer.Reset(m.opts.Timeout)
+	}
+}
+
+// Flush writes the current record, if there is one.
+func (m *multilineBuffer) Flush() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.flush()
+}
+
+// Close writes the current record and stops the timer.
+func (m *multilineBuffer) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.timer != nil {
+		m.timer.Stop()
+	}
+	m.flush()
+}
+
+func (m *multilineBuffer) continues(line string) bool {
+	if m.opts.Continue != nil && m.opts.Continue.MatchString(line) {
+		return true
+	}
+
+	return m.opts.Start != nil && !m.opts.Start.MatchString(line)
+}
+
+// flush must be called with the lock held, so that records are
+// written in order.
+func (m *multilineBuffer) flush() {
+	if len(m.lines) == 0 {
+		return
+	}
+
+	m.emit(strings.Join(m.lines, "\n"))
+
+	m.lines = m.lines[:0]
+	m.size = 0
+}
@@ -0,0 +1,203 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package executor
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordCollector struct {
+	mu      sync.Mutex
+	records []string
+}
+
+func (c *recordCollector) emit(record string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.records = append(c.records, record)
+}
+
+func (c *recordCollector) Records() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string{}, c.records...)
+}
+
+func TestMultilineBuffer_GroupsIndentedLines(t *testing.T) {
+	c := &recordCollector{}
+	m := newMultilineBuffer(MultilineOptions{
+		Continue: regexp.MustCompile(`^(\s|Caused by:)`),
+		Timeout:  time.Minute,
+		MaxBytes: 1024,
+	}, c.emit)
+
+	for _, line := range []string{
+		"Exception in thread \"main\" java.lang.IllegalStateException: boom",
+		"\tat com.example.Handler.handle(Handler.java:10)",
+		"Caused by: java.io.IOException: closed",
+		"\tat com.example.Stream.read(Stream.java:20)",
+		"next message",
+	} {
+		m.Add(line)
+	}
+	m.Close()
+
+	want := []string{
+		"Exception in thread \"main\" java.lang.IllegalStateException: boom\n\tat com.example.Handler.handle(Handler.java:10)\nCaused by: java.io.IOException: closed\n\tat com.example.Stream.read(Stream.java:20)",
+		"next message",
+	}
+	if got := c.Records(); !reflect.DeepEqual(want, got) {
+		t.Errorf("want records %q, got %q", want, got)
+	}
+}
+
+func TestMultilineBuffer_StartPattern(t *testing.T) {
+	c := &recordCollector{}
+	m := newMultilineBuffer(MultilineOptions{
+		Start:    regexp.MustCompile(`^(Traceback|INFO|ERROR)`),
+		Timeout:  time.Minute,
+		MaxBytes: 1024,
+	}, c.emit)
+
+	for _, line := range []string{
+		"Traceback (most recent call last):",
+		"  File \"handler.py\", line 3, in handle",
+		"ValueError: bad input",
+		"INFO done",
+	} {
+		m.Add(line)
+	}
+	m.Close()
+
+	want := []string{
+		"Traceback (most recent call last):\n  File \"handler.py\", line 3, in handle\nValueError: bad input",
+		"INFO done",
+	}
+	if got := c.Records(); !reflect.DeepEqual(want, got) {
+		t.Errorf("want records %q, got %q", want, got)
+	}
+}
+
+func TestMultilineBuffer_MaxBytesStartsNewRecord(t *testing.T) {
+	c := &recordCollector{}
+	m := newMultilineBuffer(MultilineOptions{
+		Continue: regexp.MustCompile(`^\s`),
+		Timeout:  time.Minute,
+		MaxBytes: 10,
+	}, c.emit)
+
+	m.Add("first")
+	m.Add(" abc")
+	m.Add(" def")
+	m.Close()
+
+	want := []string{"first\n abc", " def"}
+	if got := c.Records(); !reflect.DeepEqual(want, got) {
+		t.Errorf("want records %q, got %q", want, got)
+	}
+}
+
+func TestMultilineBuffer_FlushesAfterTimeout(t *testing.T) {
+	c := &recordCollector{}
+	m := newMultilineBuffer(MultilineOptions{
+		Continue: regexp.MustCompile(`^\s`),
+		Timeout:  50 * time.Millisecond,
+		MaxBytes: 1024,
+	}, c.emit)
+	defer m.Close()
+
+	m.Add("panic: runtime error")
+	m.Add("\tgoroutine 1 [running]:")
+
+	if got := c.Records(); len(got) != 0 {
+		t.Fatalf("want no records before the timeout, got %q", got)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	want := []string{"panic: runtime error\n\tgoroutine 1 [running]:"}
+	if got := c.Records(); !reflect.DeepEqual(want, got) {
+		t.Errorf("want records %q, got %q", want, got)
+	}
+}
+
+func TestBindLoggingPipe_MultilineJSONRecord(t *testing.T) {
+	input := "Traceback (most recent call last):\n  File \"handler.py\", line 3\nnext\n"
+
+	out := bytes.Buffer{}
+	opts := logOptions{
+		maxBufferSize: 1024,
+		format:        LogFormatJSON,
+		multiline: &MultilineOptions{
+			Continue: regexp.MustCompile(`^\s`),
+			Timeout:  time.Minute,
+			MaxBytes: 1024,
+		},
+	}
+	<-bindLoggingPipe("stderr", strings.NewReader(input), &out, opts)
+
+	var msgs []string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		record := map[string]string{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("want JSON records, got %q, error: %s", out.String(), err)
+		}
+		msgs = append(msgs, record["msg"])
+	}
+
+	want := []string{"Traceback (most recent call last):\n  File \"handler.py\", line 3", "next"}
+	if !reflect.DeepEqual(want, msgs) {
+		t.Errorf("want messages %q, got %q", want, msgs)
+	}
+}
+
+func TestBindLoggingPipe_MultilineRecordsOnOneLine(t *testing.T) {
+	cases := []struct {
+		name   string
+		input  string
+		format string
+		want   string
+	}{
+		{
+			name:  "text escapes newlines",
+			input: "Traceback (most recent call last):\n  File \"handler.py\", line 3\n",
+			want:  `Traceback (most recent call last):\n  File "handler.py", line 3` + "\n",
+		},
+		{
+			name:   "JSON object is compacted",
+			input:  "{\n  \"level\": \"error\"\n}\n",
+			format: LogFormatJSON,
+			want:   `{"level":"error","stream":"stderr"}` + "\n",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out := bytes.Buffer{}
+			opts := logOptions{
+				maxBufferSize: 1024,
+				format:        tc.format,
+				multiline: &MultilineOptions{
+					Continue: regexp.MustCompile(`^(\s|})`),
+					Timeout:  time.Minute,
+					MaxBytes: 1024,
+				},
+			}
+			<-bindLoggingPipe("stderr", strings.NewReader(tc.input), &out, opts)
+
+			if got := out.String(); got != tc.want {
+				t.Errorf("want %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
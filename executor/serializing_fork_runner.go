@@ -4,19 +4,15 @@
 package executor
 
 import (
-	"context"
 	"fmt"
 	"io"
-	"os"
-
-	units "github.com/docker/go-units"
-
-	"log"
 	"net/http"
+	"os"
 	"os/exec"
-	"strings"
 	"sync"
 	"time"
+
+	"github.com/openfaas/of-watchdog/metrics"
 )
 
 // SerializingForkFunctionRunner forks a process for each invocation
@@ -24,6 +20,15 @@ type SerializingForkFunctionRunner struct {
 	ExecTimeout   time.Duration
 	LogPrefix     bool
 	LogBufferSize int
+	LogFormat     string
+	LogFields     map[string]string
+	LogCallId     bool
+	LogOverflow   string
+	LogMetrics    *metrics.Logs
+	LogMultiline  *MultilineOptions
+	LogLimiter    *LogLimiter
+	LogSink       io.Writer
+	Metrics       *metrics.Function
 }
 
 // Run run a fork for each invocation
@@ -35,28 +40,16 @@ func (f *SerializingForkFunctionRunner) Run(req FunctionRequest, w http.Response
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 
-		done := time.Since(start)
-
-		if !strings.HasPrefix(req.UserAgent, "kube-probe") {
-			log.Printf("%s %s - %d - ContentLength: %s (%.4fs)", req.Method, req.RequestURI, http.StatusOK, units.HumanSize(float64(len(err.Error()))), done.Seconds())
-		}
-
 		return err
 	}
 
 	w.Header().Set("X-Duration-Seconds", fmt.Sprintf("%f", time.Since(start).Seconds()))
 	w.WriteHeader(200)
 
-	bodyLen := 0
 	if body != nil {
+		_, span := StartSpan(req.Context, "copy response")
 		_, err = w.Write(*body)
-		bodyLen = len(*body)
-	}
-
-	done := time.Since(start)
-
-	if !strings.HasPrefix(req.UserAgent, "kube-probe") {
-		log.Printf("%s %s - %d - ContentLength: %s (%.4fs)", req.Method, req.RequestURI, http.StatusOK, units.HumanSize(float64(bodyLen)), done.Seconds())
+		span.End()
 	}
 
 	return err
@@ -68,16 +61,14 @@ func serializeFunction(req FunctionRequest, f *SerializingForkFunctionRunner) (*
 		defer req.InputReader.Close()
 	}
 
-	var cmd *exec.Cmd
-	ctx := context.Background()
-	if f.ExecTimeout.Nanoseconds() > 0 {
-		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, f.ExecTimeout)
-		defer cancel()
-	}
+	ctx, cancel := processContext(req, f.ExecTimeout)
+	defer cancel()
+
+	spanCtx, span := StartSpan(req.Context, "fork")
+	defer span.End()
 
-	cmd = exec.CommandContext(ctx, req.Process, req.ProcessArgs...)
-	cmd.Env = req.Environment
+	cmd := exec.CommandContext(ctx, req.Process, req.ProcessArgs...)
+	cmd.Env = traceEnvironment(spanCtx, req.Environment)
 
 	var data []byte
 
@@ -102,11 +93,11 @@ func serializeFunction(req FunctionRequest, f *SerializingForkFunctionRunner) (*
 	stdin, _ := cmd.StdinPipe()
 	stderr, _ := cmd.StderrPipe()
 
-	if err := cmd.Start(); err != nil {
+	if err := f.start(cmd); err != nil {
 		return nil, err
 	}
 
-	bindLoggingPipe("stderr", stderr, os.Stderr, f.LogPrefix, f.LogBufferSize)
+	bindLoggingPipe("stderr", stderr, os.Stderr, f.logOptions(req.CallID))
 
 	functionRes, errors := pipeToProcess(stdin, stdout, &data)
 	if len(errors) > 0 {
@@ -114,10 +105,30 @@ func serializeFunction(req FunctionRequest, f *SerializingForkFunctionRunner) (*
 	}
 
 	err := cmd.Wait()
+	if execTimedOut(ctx) {
+		f.timedOut()
+	}
 
 	return functionRes, err
 }
 
+func (f *SerializingForkFunctionRunner) start(cmd *exec.Cmd) error {
+	start := time.Now()
+	err := cmd.Start()
+
+	if f.Metrics != nil && err == nil {
+		f.Metrics.SpawnDuration.Observe(time.Since(start).Seconds())
+	}
+
+	return err
+}
+
+func (f *SerializingForkFunctionRunner) timedOut() {
+	if f.Metrics != nil {
+		f.Metrics.Timeouts.Inc()
+	}
+}
+
 func pipeToProcess(stdin io.WriteCloser, stdout io.Reader, data *[]byte) (*[]byte, []error) {
 	var functionResult *[]byte
 	var errors []error
@@ -160,3 +171,21 @@ func pipeToProcess(stdin io.WriteCloser, stdout io.Reader, data *[]byte) (*[]byt
 
 	return functionResult, errors
 }
+
+// logOptions tags each line with callID, since a process is
+// forked for each invocation.
+func (f *SerializingForkFunctionRunner) logOptions(callID string) logOptions {
+	return logOptions{
+		prefix:        f.LogPrefix,
+		maxBufferSize: f.LogBufferSize,
+		format:        f.LogFormat,
+		fields:        f.LogFields,
+		callID:        callID,
+		tagCallID:     f.LogCallId,
+		overflow:      f.LogOverflow,
+		metrics:       f.LogMetrics,
+		multiline:     f.LogMultiline,
+		limiter:       f.LogLimiter,
+		sink:          f.LogSink,
+	}
+}
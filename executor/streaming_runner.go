@@ -4,10 +4,12 @@
 package executor
 
 import (
-	"context"
+	"io"
 	"os"
 	"os/exec"
 	"time"
+
+	"github.com/openfaas/of-watchdog/metrics"
 )
 
 // StreamingFunctionRunner forks a process for each invocation
@@ -15,36 +17,83 @@ type StreamingFunctionRunner struct {
 	ExecTimeout   time.Duration
 	LogPrefix     bool
 	LogBufferSize int
+	LogFormat     string
+	LogFields     map[string]string
+	LogCallId     bool
+	LogOverflow   string
+	LogMetrics    *metrics.Logs
+	LogMultiline  *MultilineOptions
+	LogLimiter    *LogLimiter
+	LogSink       io.Writer
+	Metrics       *metrics.Function
 }
 
 // Run run a fork for each invocation
 func (f *StreamingFunctionRunner) Run(req FunctionRequest) error {
 
-	var cmd *exec.Cmd
-	ctx := context.Background()
-	if f.ExecTimeout.Nanoseconds() > 0 {
-		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, f.ExecTimeout)
-		defer cancel()
-	}
+	ctx, cancel := processContext(req, f.ExecTimeout)
+	defer cancel()
 
-	cmd = exec.CommandContext(ctx, req.Process, req.ProcessArgs...)
+	cmd := exec.CommandContext(ctx, req.Process, req.ProcessArgs...)
 	if req.InputReader != nil {
 		defer req.InputReader.Close()
 		cmd.Stdin = req.InputReader
 	}
 
-	cmd.Env = req.Environment
+	spanCtx, span := StartSpan(req.Context, "fork")
+	defer span.End()
+
+	cmd.Env = traceEnvironment(spanCtx, req.Environment)
 	cmd.Stdout = req.OutputWriter
 
 	errPipe, _ := cmd.StderrPipe()
 
 	// Prints stderr to console and is picked up by container logging driver.
-	bindLoggingPipe("stderr", errPipe, os.Stderr, f.LogPrefix, f.LogBufferSize)
+	bindLoggingPipe("stderr", errPipe, os.Stderr, f.logOptions(req.CallID))
 
-	if err := cmd.Start(); err != nil {
+	if err := f.start(cmd); err != nil {
 		return err
 	}
 
-	return cmd.Wait()
+	err := cmd.Wait()
+	if execTimedOut(ctx) {
+		f.timedOut()
+	}
+
+	return err
+}
+
+func (f *StreamingFunctionRunner) start(cmd *exec.Cmd) error {
+	start := time.Now()
+	err := cmd.Start()
+
+	if f.Metrics != nil && err == nil {
+		f.Metrics.SpawnDuration.Observe(time.Since(start).Seconds())
+	}
+
+	return err
+}
+
+func (f *StreamingFunctionRunner) timedOut() {
+	if f.Metrics != nil {
+		f.Metrics.Timeouts.Inc()
+	}
+}
+
+// logOptions tags each line with callID, since a process is
+// forked for each invocation.
+func (f *StreamingFunctionRunner) logOptions(callID string) logOptions {
+	return logOptions{
+		prefix:        f.LogPrefix,
+		maxBufferSize: f.LogBufferSize,
+		format:        f.LogFormat,
+		fields:        f.LogFields,
+		callID:        callID,
+		tagCallID:     f.LogCallId,
+		overflow:      f.LogOverflow,
+		metrics:       f.LogMetrics,
+		multiline:     f.LogMultiline,
+		limiter:       f.LogLimiter,
+		sink:          f.LogSink,
+	}
 }
@@ -0,0 +1,47 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package executor
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/openfaas/of-watchdog/executor"
+
+// StartSpan starts a child of the span in ctx, using the same provider.
+// When there is no span in ctx, the span is not recorded.
+func StartSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName).Start(ctx, name)
+}
+
+// traceEnvironment appends the W3C trace context of ctx to env as
+// TRACEPARENT and TRACESTATE, so that a forked process can continue the
+// trace. A nil env is inherited from the watchdog, as with exec.Cmd.
+func traceEnvironment(ctx context.Context, env []string) []string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+
+	if len(carrier) == 0 {
+		return env
+	}
+
+	if env == nil {
+		env = os.Environ()
+	}
+
+	for _, key := range carrier.Keys() {
+		env = append(env, strings.ToUpper(key)+"="+carrier.Get(key))
+	}
+
+	return env
+}
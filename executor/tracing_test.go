@@ -0,0 +1,37 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package executor
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTraceEnvironment_AppendsTraceParent(t *testing.T) {
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+
+	env := traceEnvironment(ctx, []string{"Http_Method=GET"})
+
+	want := "TRACEPARENT=00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	if len(env) != 2 || env[0] != "Http_Method=GET" || env[1] != want {
+		t.Errorf("want env: [Http_Method=GET %s], got: %v", want, env)
+	}
+}
+
+func TestTraceEnvironment_UnchangedWithoutSpan(t *testing.T) {
+	env := traceEnvironment(context.Background(), nil)
+
+	if env != nil {
+		t.Errorf("want nil env to be inherited, got: %v", env)
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package logsink
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is added to the name of a rotated file, so
+// that backups sort in the order they were rotated.
+const backupTimeFormat = "20060102T150405.000000000"
+
+// FileOptions control when a File is rotated, and which backups are kept.
+type FileOptions struct {
+	// MaxSize is the size in bytes at which the file is rotated,
+	// or 0 for no limit.
+	MaxSize int64
+
+	// MaxAge is how long to write to a file before it is rotated,
+	// or 0 for no limit.
+	MaxAge time.Duration
+
+	// MaxBackups is the number of rotated files to keep, or 0 to
+	// keep them all.
+	MaxBackups int
+
+	// Compress gzips each rotated file.
+	Compress bool
+}
+
+// File writes logs to a local file, which is rotated by size and age.
+type File struct {
+	path string
+	opts FileOptions
+	now  func() time.Time
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	opened  time.Time
+	pending sync.WaitGroup
+
+	// cleanup runs one compression or removal of backups at a time.
+	cleanup sync.Mutex
+}
+
+// NewFile opens path for appending, now defaults to time.Now when nil.
+func NewFile(path string, opts FileOptions, now func() time.Time) (*File, error) {
+	if now == nil {
+		now = time.Now
+	}
+
+	f := &File{
+		path: path,
+		opts: opts,
+		now:  now,
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening log file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.opened = f.now()
+	return nil
+}
+
+// Write appends p to the file, rotating it first when p would take it
+// over the maximum size, or it is over the maximum age.
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	overSize := f.opts.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.opts.MaxSize
+	overAge := f.opts.MaxAge > 0 && f.now().Sub(f.opened) >= f.opts.MaxAge
+
+	if overSize || overAge {
+		// The current file is kept when rotation fails, and rotation
+		// is tried again on the next write.
+		if err := f.rotate(); err != nil {
+			log.Printf("Error rotating log file %s: %s", f.path, err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate must be called with the lock held. The file is renamed whilst
+// it is still open, so that it can be written to until a new file is
+// opened in its place.
+func (f *File) rotate() error {
+	backup := f.path + "." + f.now().UTC().Format(backupTimeFormat)
+	if err := os.Rename(f.path, backup); err != nil {
+		return err
+	}
+
+	previous := f.file
+	if err := f.open(); err != nil {
+		if restoreErr := os.Rename(backup, f.path); restoreErr != nil {
+			log.Printf("Error restoring log file %s: %s", f.path, restoreErr)
+		}
+		return err
+	}
+
+	if err := previous.Close(); err != nil {
+		log.Printf("Error closing rotated log file %s: %s", backup, err)
+	}
+
+	f.pending.Add(1)
+	go func() {
+		defer f.pending.Done()
+
+		f.cleanup.Lock()
+		defer f.cleanup.Unlock()
+
+		if f.opts.Compress {
+			if err := compress(backup); err != nil {
+				log.Printf("Error compressing log file %s: %s", backup, err)
+			}
+		}
+
+		f.removeOldBackups()
+	}()
+
+	return nil
+}
+
+// removeOldBackups deletes the oldest backups over MaxBackups.
+func (f *File) removeOldBackups() {
+	if f.opts.MaxBackups <= 0 {
+		return
+	}
+
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return
+	}
+
+	var backups []string
+	for _, match := range matches {
+		// Skip a backup which is still being compressed.
+		if strings.HasSuffix(match, ".tmp") {
+			continue
+		}
+		backups = append(backups, match)
+	}
+	sort.Strings(backups)
+
+	for len(backups) > f.opts.MaxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+}
+
+func compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// Close waits for any backups to be compressed, then closes the file.
+func (f *File) Close() error {
+	f.pending.Wait()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.file.Close()
+}
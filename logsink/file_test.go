@@ -0,0 +1,126 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package logsink
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestFile_RotatesBySizeAndCompresses(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "function.log")
+
+	now := time.Unix(0, 0)
+	f, err := NewFile(path, FileOptions{MaxSize: 10, Compress: true}, func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Write([]byte("0123456\n"))
+	f.Write([]byte("abcdefg\n"))
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	current, _ := os.ReadFile(path)
+	if string(current) != "abcdefg\n" {
+		t.Errorf("want the second line in the current file, got: %q", current)
+	}
+
+	backups, _ := filepath.Glob(path + ".*.gz")
+	if len(backups) != 1 {
+		t.Fatalf("want one compressed backup, got: %v", backups)
+	}
+
+	gzFile, _ := os.Open(backups[0])
+	defer gzFile.Close()
+	r, err := gzip.NewReader(gzFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, _ := io.ReadAll(r)
+	if string(rotated) != "0123456\n" {
+		t.Errorf("want the first line in the backup, got: %q", rotated)
+	}
+}
+
+func TestFile_RotatesByAgeAndKeepsMaxBackups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "function.log")
+
+	now := time.Unix(0, 0)
+	f, err := NewFile(path, FileOptions{MaxAge: time.Minute, MaxBackups: 2}, func() time.Time { return now })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n"} {
+		f.Write([]byte(line))
+		now = now.Add(time.Minute)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, _ := filepath.Glob(path + ".*")
+	sort.Strings(backups)
+	if len(backups) != 2 {
+		t.Fatalf("want 2 backups kept, got: %v", backups)
+	}
+
+	newest, _ := os.ReadFile(backups[1])
+	if string(newest) != "three\n" {
+		t.Errorf("want the newest backup to hold %q, got: %q", "three\n", newest)
+	}
+}
+
+func TestFile_KeepsWritingWhenRotationFails(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "function.log")
+
+	now := time.Unix(0, 0)
+	f, err := NewFile(path, FileOptions{MaxSize: 10}, func() time.Time { return now })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A directory in the way of the backup makes the rename fail.
+	blocked := path + "." + now.UTC().Format(backupTimeFormat)
+	if err := os.MkdirAll(filepath.Join(blocked, "in-the-way"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	f.Write([]byte("0123456\n"))
+	if _, err := f.Write([]byte("abcdefg\n")); err != nil {
+		t.Fatalf("want the write to succeed whilst rotation fails, got: %s", err)
+	}
+
+	now = now.Add(time.Second)
+	f.Write([]byte("rotated\n"))
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	current, _ := os.ReadFile(path)
+	if string(current) != "rotated\n" {
+		t.Errorf("want the line after rotation in the current file, got: %q", current)
+	}
+
+	backup, _ := os.ReadFile(path + "." + now.UTC().Format(backupTimeFormat))
+	if string(backup) != "0123456\nabcdefg\n" {
+		t.Errorf("want the lines written whilst rotation failed in the backup, got: %q", backup)
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+// Package logsink provides destinations for logs in addition to the
+// container's stdout and stderr.
+package logsink
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// facilityUser is the syslog facility for user-level messages.
+	facilityUser = 1
+
+	// severityInfo is the syslog severity for informational messages.
+	severityInfo = 6
+
+	// writeTimeout stops a slow syslog server from blocking the function.
+	writeTimeout = time.Second
+
+	// reconnectBackoff is how long lines are dropped for after failing
+	// to connect, rather than each line waiting to connect again.
+	reconnectBackoff = 5 * time.Second
+
+	// maxAppNameLength is the longest APP-NAME allowed by RFC 5424.
+	maxAppNameLength = 48
+)
+
+// Syslog writes each line as an RFC 5424 message over UDP, TCP or a
+// unix socket. Messages sent over a stream are framed by octet counting,
+// as per RFC 6587.
+type Syslog struct {
+	network  string
+	address  string
+	appName  string
+	hostname string
+	now      func() time.Time
+
+	mu        sync.Mutex
+	conn      net.Conn
+	nextRetry time.Time
+	closed    bool
+}
+
+// NewSyslog connects to address, given as a URL with a scheme of udp,
+// tcp, unix or unixgram, i.e. udp://127.0.0.1:514 or unixgram:///dev/log.
+// appName must be printable ASCII without spaces, as per RFC 5424.
+func NewSyslog(address, appName string) (*Syslog, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid syslog address %q: %w", address, err)
+	}
+
+	if !validAppName(appName) {
+		return nil, fmt.Errorf("invalid syslog app name %q, it must be 1 to %d printable ASCII characters without spaces", appName, maxAppNameLength)
+	}
+
+	s := &Syslog{
+		network: u.Scheme,
+		appName: appName,
+		now:     time.Now,
+	}
+
+	switch u.Scheme {
+	case "udp", "tcp":
+		s.address = u.Host
+	case "unix", "unixgram":
+		s.address = u.Path
+	default:
+		return nil, fmt.Errorf("invalid syslog address %q, the scheme must be udp, tcp, unix or unixgram", address)
+	}
+
+	s.hostname, _ = os.Hostname()
+	if len(s.hostname) == 0 {
+		s.hostname = "-"
+	}
+
+	// The connection is retried by a write after the reconnectBackoff,
+	// so that logs are sent once a syslog server which is down comes
+	// back.
+	if err := s.connect(); err != nil {
+		log.Printf("Unable to connect to syslog at %s, will retry: %s", address, err)
+	}
+
+	return s, nil
+}
+
+func (s *Syslog) connect() error {
+	conn, err := net.DialTimeout(s.network, s.address, writeTimeout)
+	if err != nil {
+		s.nextRetry = s.now().Add(reconnectBackoff)
+		return fmt.Errorf("error connecting to syslog: %w", err)
+	}
+
+	s.conn = conn
+	return nil
+}
+
+// Write sends each line in p as a message. When a line can't be sent,
+// the bytes of the lines before it are returned with the error.
+func (s *Syslog) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, net.ErrClosed
+	}
+
+	written := 0
+	for rest := p; len(rest) > 0; {
+		line, next, found := bytes.Cut(rest, []byte("\n"))
+
+		if len(line) > 0 {
+			if err := s.send(s.format(line)); err != nil {
+				return written, err
+			}
+		}
+
+		written += len(line)
+		if found {
+			written++
+		}
+		rest = next
+	}
+
+	return written, nil
+}
+
+// send writes msg, reconnecting once if the connection has failed. Whilst
+// disconnected, msg is dropped until the reconnectBackoff has passed.
+func (s *Syslog) send(msg []byte) error {
+	if s.conn == nil {
+		if s.now().Before(s.nextRetry) {
+			return fmt.Errorf("not connected to syslog, dropping logs until %s", s.nextRetry.Format(time.RFC3339))
+		}
+
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+
+	if err := s.write(msg); err != nil {
+		s.conn.Close()
+		s.conn = nil
+
+		if err := s.connect(); err != nil {
+			return err
+		}
+
+		return s.write(msg)
+	}
+
+	return nil
+}
+
+func (s *Syslog) write(msg []byte) error {
+	s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+
+	if s.network == "tcp" || s.network == "unix" {
+		msg = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	}
+
+	_, err := s.conn.Write(msg)
+	return err
+}
+
+// format creates an RFC 5424 message, without structured data.
+func (s *Syslog) format(line []byte) []byte {
+	header := fmt.Sprintf("<%d>1 %s %s %s %d - - ",
+		facilityUser*8+severityInfo,
+		time.Now().UTC().Format(time.RFC3339Nano),
+		s.hostname,
+		s.appName,
+		os.Getpid())
+
+	return append([]byte(header), line...)
+}
+
+// validAppName returns true for an RFC 5424 APP-NAME.
+func validAppName(name string) bool {
+	if len(name) == 0 || len(name) > maxAppNameLength {
+		return false
+	}
+
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Close closes the connection to the syslog server, after which
+// writes fail rather than connecting again.
+func (s *Syslog) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	if s.conn == nil {
+		return nil
+	}
+
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
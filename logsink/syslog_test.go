@@ -0,0 +1,254 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package logsink
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc5424 matches a message without structured data.
+var rfc5424 = regexp.MustCompile(`^<14>1 \S+ \S+ figlet \d+ - - (.*)$`)
+
+func TestSyslog_UDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s, err := NewSyslog("udp://"+conn.LocalAddr().String(), "figlet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if _, err := s.Write([]byte("first line\nsecond line\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"first line", "second line"} {
+		buf := make([]byte, 1024)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := string(buf[:n])
+		match := rfc5424.FindStringSubmatch(got)
+		if match == nil || match[1] != want {
+			t.Errorf("want RFC 5424 message with %q, got: %q", want, got)
+		}
+	}
+}
+
+func TestSyslog_TCPOctetCounting(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		length, err := r.ReadString(' ')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(length))
+
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return
+		}
+		received <- string(msg)
+	}()
+
+	s, err := NewSyslog("tcp://"+l.Addr().String(), "figlet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	fmt.Fprintln(s, "over tcp")
+
+	select {
+	case got := <-received:
+		match := rfc5424.FindStringSubmatch(got)
+		if match == nil || match[1] != "over tcp" {
+			t.Errorf("want RFC 5424 message with %q, got: %q", "over tcp", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("want message received by the syslog listener")
+	}
+}
+
+func TestNewSyslog_InvalidScheme(t *testing.T) {
+	if _, err := NewSyslog("http://127.0.0.1:514", "figlet"); err == nil {
+		t.Fatal("want error for a scheme which is not supported")
+	}
+}
+
+func TestSyslog_DropsLinesWhilstDown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	s, err := NewSyslog("tcp://"+address, "figlet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if s.nextRetry.IsZero() {
+		t.Fatal("want a retry to be scheduled after failing to connect")
+	}
+
+	now := s.nextRetry.Add(-time.Second)
+	s.now = func() time.Time { return now }
+
+	l, err = net.Listen("tcp", address)
+	if err != nil {
+		t.Skipf("unable to listen on %s again: %s", address, err)
+	}
+	defer l.Close()
+
+	received := make(chan string, 2)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		for {
+			length, err := r.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(length))
+
+			msg := make([]byte, n)
+			if _, err := io.ReadFull(r, msg); err != nil {
+				return
+			}
+			received <- string(msg)
+		}
+	}()
+
+	if _, err := fmt.Fprintln(s, "dropped"); err == nil {
+		t.Error("want error for a line dropped before the backoff has passed")
+	}
+
+	now = now.Add(time.Second)
+	if _, err := fmt.Fprintln(s, "sent"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-received:
+		match := rfc5424.FindStringSubmatch(got)
+		if match == nil || match[1] != "sent" {
+			t.Errorf("want only the line written after the backoff, got: %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("want message received once reconnected")
+	}
+}
+
+func TestNewSyslog_InvalidAppName(t *testing.T) {
+	for _, name := range []string{"", "my function", "caf\u00e9", strings.Repeat("a", 49)} {
+		if _, err := NewSyslog("udp://127.0.0.1:514", name); err == nil {
+			t.Errorf("want error for app name %q", name)
+		}
+	}
+}
+
+// failingConn accepts one write, then fails the rest.
+type failingConn struct {
+	net.Conn
+	writes int
+}
+
+func (c *failingConn) Write(p []byte) (int, error) {
+	c.writes++
+	if c.writes > 1 {
+		return 0, errors.New("connection reset")
+	}
+	return len(p), nil
+}
+
+func (c *failingConn) SetWriteDeadline(time.Time) error { return nil }
+
+func (c *failingConn) Close() error { return nil }
+
+func TestSyslog_WriteReturnsBytesSent(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	s, err := NewSyslog("tcp://"+address, "figlet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	s.conn = &failingConn{}
+	s.nextRetry = time.Time{}
+
+	n, err := s.Write([]byte("sent\nnot sent\n"))
+	if err == nil {
+		t.Fatal("want an error for the line which was not sent")
+	}
+	if n != len("sent\n") {
+		t.Errorf("want %d bytes written, got: %d", len("sent\n"), n)
+	}
+}
+
+func TestSyslog_WriteAfterClose(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s, err := NewSyslog("udp://"+conn.LocalAddr().String(), "figlet")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Write([]byte("after close\n")); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("want net.ErrClosed, got: %v", err)
+	}
+	if s.conn != nil {
+		t.Error("want the connection to stay closed")
+	}
+}
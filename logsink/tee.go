@@ -0,0 +1,26 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package logsink
+
+import "io"
+
+// Tee returns a writer which writes to each of writers. Unlike
+// io.MultiWriter, a failing writer does not stop the others from
+// being written to.
+func Tee(writers ...io.Writer) io.Writer {
+	return tee(writers)
+}
+
+type tee []io.Writer
+
+func (t tee) Write(p []byte) (int, error) {
+	var firstErr error
+	for _, w := range t {
+		if _, err := w.Write(p); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return len(p), firstErr
+}
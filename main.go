@@ -15,6 +15,12 @@ import (
 )
 
 func main() {
+	// The probe subcommand prints nothing on success, as it is run for
+	// each exec probe.
+	if len(os.Args) > 1 && os.Args[1] == "probe" {
+		os.Exit(runProbe(os.Args[2:]))
+	}
+
 	var runHealthcheck bool
 	var versionFlag bool
 
@@ -38,7 +44,11 @@ func main() {
 		os.Exit(1)
 	}
 
-	w := pkg.NewWatchdog(watchdogConfig)
+	w, err := pkg.New(pkg.WithConfig(watchdogConfig))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating watchdog: %s", err.Error())
+		os.Exit(1)
+	}
 
 	if runHealthcheck {
 		if w.LockFilePresent() {
@@ -52,10 +62,14 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	if err := w.Start(ctx); err != nil {
+	if err := w.Run(ctx); err != nil {
 		log.Printf("Error: %s\n", err.Error())
 		os.Exit(1)
 	}
+
+	if w.StoppedIdle() {
+		os.Exit(watchdogConfig.IdleExitCode)
+	}
 }
 
 func printVersion() {
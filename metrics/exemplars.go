@@ -0,0 +1,61 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxExemplarValue keeps the labels of an exemplar within the 128
+// runes allowed by OpenMetrics, a longer call ID is left out.
+const maxExemplarValue = 64
+
+type exemplarKey struct{}
+
+// exemplar collects the IDs of a request which are only known once
+// it has been handled, such as the trace started for it.
+type exemplar struct {
+	mu      sync.Mutex
+	traceID string
+	header  http.Header
+}
+
+// SetTraceID records the ID of the sampled trace of the request, for
+// its exemplar.
+func SetTraceID(ctx context.Context, traceID string) {
+	if e, ok := ctx.Value(exemplarKey{}).(*exemplar); ok {
+		e.mu.Lock()
+		e.traceID = traceID
+		e.mu.Unlock()
+	}
+}
+
+// exemplarLabels returns the trace ID of the request when it was
+// traced, otherwise its call ID.
+func exemplarLabels(ctx context.Context) prometheus.Labels {
+	e, ok := ctx.Value(exemplarKey{}).(*exemplar)
+	if !ok {
+		return nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.traceID) > 0 {
+		return prometheus.Labels{"trace_id": e.traceID}
+	}
+
+	// The call ID may only be set whilst the request is handled, so
+	// it is read from the header afterwards.
+	if callID := e.header.Get("X-Call-Id"); len(callID) > 0 && utf8.RuneCountInString(callID) <= maxExemplarValue {
+		return prometheus.Labels{"call_id": callID}
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Function records how the function process itself behaves, apart
+// from the HTTP requests made to the watchdog.
+type Function struct {
+	// SpawnDuration records how long it takes to start the process
+	// in the fork modes.
+	SpawnDuration prometheus.Histogram
+
+	// Timeouts counts invocations stopped by exec_timeout.
+	Timeouts prometheus.Counter
+
+	// UpstreamErrors counts requests which could not be proxied to
+	// the function in http mode, such as when it has crashed, by the
+	// reason for the error.
+	UpstreamErrors *prometheus.CounterVec
+
+	// Restarts counts restarts of the function process in http mode,
+	// such as when its memory is over process_max_rss.
+	Restarts prometheus.Counter
+}
+
+// NewFunction creates the function metrics and registers them with reg.
+func NewFunction(reg prometheus.Registerer) *Function {
+	factory := with(reg)
+
+	return &Function{
+		SpawnDuration: factory.NewHistogram(prometheus.HistogramOpts{
+			Subsystem: "function",
+			Name:      "spawn_duration_seconds",
+			Help:      "Seconds spent starting the function process in the fork modes.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 12),
+		}),
+		Timeouts: factory.NewCounter(prometheus.CounterOpts{
+			Subsystem: "function",
+			Name:      "timeouts_total",
+			Help:      "total invocations stopped by the exec timeout",
+		}),
+		UpstreamErrors: factory.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: "function",
+			Name:      "upstream_errors_total",
+			Help:      "total requests which could not be proxied to the function",
+		}, []string{"reason"}),
+		Restarts: factory.NewCounter(prometheus.CounterOpts{
+			Subsystem: "function",
+			Name:      "restarts_total",
+			Help:      "total restarts of the function process",
+		}),
+	}
+}
@@ -4,37 +4,196 @@
 package metrics
 
 import (
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 )
 
 type Http struct {
 	RequestsTotal            *prometheus.CounterVec
 	RequestDurationHistogram *prometheus.HistogramVec
 	InFlight                 prometheus.Gauge
+
+	// RequestSizeHistogram and ResponseSizeHistogram record the
+	// approximate size of each request and of each response body.
+	RequestSizeHistogram  *prometheus.HistogramVec
+	ResponseSizeHistogram *prometheus.HistogramVec
+
+	// Rejections counts requests turned away by the watchdog before
+	// reaching the function, by the limiter which rejected them.
+	Rejections *prometheus.CounterVec
+
+	// ClientCancellations counts requests which the client gave up on
+	// before the response was complete.
+	ClientCancellations prometheus.Counter
+
+	// InFlightLimit is the static or adaptive limit for
+	// requests in-flight, when a limit is configured.
+	InFlightLimit prometheus.Gauge
+
+	// LimitRejections counts requests rejected by a limit rule,
+	// by the rule name and the reason.
+	LimitRejections *prometheus.CounterVec
+
+	// PriorityAdmitted, PriorityShed and PriorityDuration are
+	// recorded by priority class when priority_lanes is enabled.
+	PriorityAdmitted *prometheus.CounterVec
+	PriorityShed     *prometheus.CounterVec
+	PriorityDuration *prometheus.HistogramVec
+
+	// QueueDepth and QueueWaitHistogram are only updated when
+	// requests are queued for a slot, see max_inflight_queue.
+	QueueDepth         prometheus.Gauge
+	QueueWaitHistogram *prometheus.HistogramVec
+
+	routes    *Routes
+	exemplars bool
+	load      *loadTracker
 }
 
-func NewHttp() Http {
+// HttpOptions configure the HTTP metrics.
+type HttpOptions struct {
+	// Routes adds a path label to the request metrics, with the route
+	// template each path matches, when set.
+	Routes *Routes
+
+	// Buckets are the upper bounds in seconds of the duration
+	// histograms, prometheus.DefBuckets when empty.
+	Buckets []float64
+
+	// NativeHistograms records native histograms alongside the
+	// classic buckets.
+	NativeHistograms bool
+
+	// Exemplars attaches the trace ID or call ID of a request to the
+	// request metrics.
+	Exemplars bool
+}
+
+// histogram adds the settings for native histograms to o, when enabled.
+func (opts HttpOptions) histogram(o prometheus.HistogramOpts) prometheus.HistogramOpts {
+	if opts.NativeHistograms {
+		o.NativeHistogramBucketFactor = 1.1
+		o.NativeHistogramMaxBucketNumber = 160
+		o.NativeHistogramMinResetDuration = time.Hour
+	}
+
+	return o
+}
+
+// NewHttp creates the HTTP metrics and registers them with reg.
+func NewHttp(reg prometheus.Registerer, opts HttpOptions) Http {
+	factory := with(reg)
+
+	buckets := opts.Buckets
+	if len(buckets) == 0 {
+		buckets = prometheus.DefBuckets
+	}
+
+	labels := []string{"code", "method"}
+	if opts.Routes != nil {
+		labels = append(labels, "path")
+	}
+
 	h := Http{
-		RequestsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		RequestsTotal: factory.NewCounterVec(prometheus.CounterOpts{
 			Subsystem: "http",
 			Name:      "requests_total",
 			Help:      "total HTTP requests processed",
-		}, []string{"code", "method"}),
-		RequestDurationHistogram: promauto.NewHistogramVec(prometheus.HistogramOpts{
+		}, labels),
+		RequestDurationHistogram: factory.NewHistogramVec(opts.histogram(prometheus.HistogramOpts{
 			Subsystem: "http",
 			Name:      "request_duration_seconds",
 			Help:      "Seconds spent serving HTTP requests.",
-			Buckets:   prometheus.DefBuckets,
-		}, []string{"code", "method"}),
-		InFlight: promauto.NewGauge(prometheus.GaugeOpts{
+			Buckets:   buckets,
+		}), labels),
+		InFlight: factory.NewGauge(prometheus.GaugeOpts{
 			Subsystem: "http",
 			Name:      "requests_in_flight",
 			Help:      "total HTTP requests in-flight",
 		}),
+		RequestSizeHistogram: factory.NewHistogramVec(opts.histogram(prometheus.HistogramOpts{
+			Subsystem: "http",
+			Name:      "request_size_bytes",
+			Help:      "Approximate size of HTTP requests in bytes.",
+			Buckets:   sizeBuckets,
+		}), labels),
+		ResponseSizeHistogram: factory.NewHistogramVec(opts.histogram(prometheus.HistogramOpts{
+			Subsystem: "http",
+			Name:      "response_size_bytes",
+			Help:      "Size of HTTP response bodies in bytes.",
+			Buckets:   sizeBuckets,
+		}), labels),
+		Rejections: factory.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: "http",
+			Name:      "rejections_total",
+			Help:      "total HTTP requests rejected by the watchdog before reaching the function",
+		}, []string{"limiter"}),
+		ClientCancellations: factory.NewCounter(prometheus.CounterOpts{
+			Subsystem: "http",
+			Name:      "client_cancellations_total",
+			Help:      "total HTTP requests cancelled by the client before the response was complete",
+		}),
+		InFlightLimit: factory.NewGauge(prometheus.GaugeOpts{
+			Subsystem: "http",
+			Name:      "requests_in_flight_limit",
+			Help:      "limit for HTTP requests in-flight",
+		}),
+		LimitRejections: factory.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: "http",
+			Name:      "limit_rejections_total",
+			Help:      "total HTTP requests rejected by a limit rule",
+		}, []string{"rule", "reason"}),
+		PriorityAdmitted: factory.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: "http",
+			Name:      "priority_admitted_total",
+			Help:      "total HTTP requests admitted by priority class",
+		}, []string{"class"}),
+		PriorityShed: factory.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: "http",
+			Name:      "priority_shed_total",
+			Help:      "total HTTP requests rejected by priority class",
+		}, []string{"class"}),
+		PriorityDuration: factory.NewHistogramVec(opts.histogram(prometheus.HistogramOpts{
+			Subsystem: "http",
+			Name:      "priority_request_duration_seconds",
+			Help:      "Seconds spent serving HTTP requests by priority class.",
+			Buckets:   buckets,
+		}), []string{"class"}),
+		QueueDepth: factory.NewGauge(prometheus.GaugeOpts{
+			Subsystem: "http",
+			Name:      "queue_depth",
+			Help:      "HTTP requests waiting for an in-flight slot",
+		}),
+		QueueWaitHistogram: factory.NewHistogramVec(opts.histogram(prometheus.HistogramOpts{
+			Subsystem: "http",
+			Name:      "queue_wait_seconds",
+			Help:      "Seconds spent waiting in the queue for an in-flight slot.",
+			Buckets:   buckets,
+		}), []string{"outcome"}),
 	}
 
+	h.routes = opts.Routes
+	h.exemplars = opts.Exemplars
+	h.load = newLoadTracker(time.Now)
+
 	// Default to 0 for queries during graceful shutdown.
 	h.InFlight.Set(0)
 	return h
 }
+
+// sizeBuckets run from 256 bytes to 64MB.
+var sizeBuckets = prometheus.ExponentialBuckets(256, 4, 10)
+
+// NewRegistry returns a Prometheus registry with the Go runtime and
+// process collectors that are found on the default registry.
+func NewRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return reg
+}
@@ -0,0 +1,114 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// rateWindow is how far back the request rate is averaged over.
+const rateWindow = 10
+
+// Load is a summary of how busy the watchdog is, for an autoscaler.
+type Load struct {
+	// InFlight is the number of requests being served.
+	InFlight float64 `json:"inflight"`
+
+	// Limit is the static or adaptive limit for requests in-flight,
+	// or 0 when there is no limit.
+	Limit float64 `json:"limit"`
+
+	// QueueDepth is the number of requests waiting for a slot.
+	QueueDepth float64 `json:"queue_depth"`
+
+	// Rate is the requests per second over the last 10 seconds.
+	Rate float64 `json:"rate"`
+
+	// IdleMs is the milliseconds since a request last started or
+	// finished, or since the watchdog started when there have been
+	// none.
+	IdleMs int64 `json:"idle_ms"`
+}
+
+// loadTracker counts recent requests in one second buckets.
+type loadTracker struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	last    time.Time
+	seconds [rateWindow]int64
+	counts  [rateWindow]int64
+}
+
+func newLoadTracker(now func() time.Time) *loadTracker {
+	return &loadTracker{now: now, last: now()}
+}
+
+// started records a request arriving.
+func (l *loadTracker) started() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.last = l.now()
+
+	second := l.last.Unix()
+	i := second % rateWindow
+	if l.seconds[i] != second {
+		l.seconds[i] = second
+		l.counts[i] = 0
+	}
+	l.counts[i]++
+}
+
+// finished records a request completing, so that a long running request
+// does not make the watchdog appear idle.
+func (l *loadTracker) finished() {
+	l.mu.Lock()
+	l.last = l.now()
+	l.mu.Unlock()
+}
+
+func (l *loadTracker) rateAndIdle() (float64, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	second := now.Unix()
+
+	var total int64
+	for i := range l.seconds {
+		if second-l.seconds[i] < rateWindow {
+			total += l.counts[i]
+		}
+	}
+
+	return float64(total) / rateWindow, now.Sub(l.last)
+}
+
+// Load reads the current load from the same gauges which are exposed to
+// Prometheus, along with the recent request rate.
+func (h Http) Load() Load {
+	rate, idle := h.load.rateAndIdle()
+
+	return Load{
+		InFlight:   gaugeValue(h.InFlight),
+		Limit:      gaugeValue(h.InFlightLimit),
+		QueueDepth: gaugeValue(h.QueueDepth),
+		Rate:       rate,
+		IdleMs:     idle.Milliseconds(),
+	}
+}
+
+func gaugeValue(g prometheus.Gauge) float64 {
+	m := &dto.Metric{}
+	if err := g.Write(m); err != nil {
+		return 0
+	}
+
+	return m.GetGauge().GetValue()
+}
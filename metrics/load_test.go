@@ -0,0 +1,59 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func Test_Load_RateAndIdle(t *testing.T) {
+	now := time.Unix(1000, 0)
+	clock := func() time.Time { return now }
+
+	h := NewHttp(prometheus.NewRegistry(), HttpOptions{})
+	h.load = newLoadTracker(clock)
+
+	handler := InstrumentHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), h)
+	for i := 0; i < 20; i++ {
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+
+	now = now.Add(1500 * time.Millisecond)
+	load := h.Load()
+	if load.Rate != 2 {
+		t.Errorf("want rate of 2 requests per second, got: %f", load.Rate)
+	}
+	if load.IdleMs != 1500 {
+		t.Errorf("want idle for 1500ms, got: %d", load.IdleMs)
+	}
+
+	now = now.Add(rateWindow * time.Second)
+	if load := h.Load(); load.Rate != 0 {
+		t.Errorf("want rate of 0 after the window, got: %f", load.Rate)
+	}
+}
+
+func Test_Load_SharesGauges(t *testing.T) {
+	h := NewHttp(prometheus.NewRegistry(), HttpOptions{})
+	h.InFlightLimit.Set(10)
+	h.QueueDepth.Set(3)
+
+	var load Load
+	handler := InstrumentHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		load = h.Load()
+	}), h)
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if load.InFlight != 1 || load.Limit != 10 || load.QueueDepth != 3 {
+		t.Errorf("want 1 in-flight, limit of 10 and queue depth of 3, got: %+v", load)
+	}
+	if load.IdleMs != 0 {
+		t.Errorf("want no idle time during a request, got: %d", load.IdleMs)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Logs counts lines read from the function's stdout and stderr which
+// could not be passed through as they were, by stream.
+type Logs struct {
+	// LinesSplit counts lines longer than log_buffer_size which
+	// were written in several parts.
+	LinesSplit *prometheus.CounterVec
+
+	// LinesTruncated counts lines longer than log_buffer_size which
+	// were cut short, and BytesDropped the bytes which were cut.
+	LinesTruncated *prometheus.CounterVec
+	BytesDropped   *prometheus.CounterVec
+
+	// LinesDropped counts lines suppressed by the log rate limit.
+	LinesDropped *prometheus.CounterVec
+}
+
+// NewLogs creates the function log metrics and registers them with reg.
+func NewLogs(reg prometheus.Registerer) *Logs {
+	factory := with(reg)
+
+	return &Logs{
+		LinesSplit: factory.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: "function_log",
+			Name:      "lines_split_total",
+			Help:      "total overlong log lines from the function written in several parts",
+		}, []string{"stream"}),
+		LinesTruncated: factory.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: "function_log",
+			Name:      "lines_truncated_total",
+			Help:      "total overlong log lines from the function which were truncated",
+		}, []string{"stream"}),
+		BytesDropped: factory.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: "function_log",
+			Name:      "bytes_dropped_total",
+			Help:      "total bytes of log output from the function which were dropped",
+		}, []string{"stream"}),
+		LinesDropped: factory.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: "function_log",
+			Name:      "lines_dropped_total",
+			Help:      "total log lines from the function suppressed by the rate limit",
+		}, []string{"stream"}),
+	}
+}
@@ -7,16 +7,31 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // MetricsServer provides instrumentation for HTTP calls
 type MetricsServer struct {
-	s    *http.Server
-	port int
+	s        *http.Server
+	port     int
+	listener net.Listener
+
+	// Gatherer is the source of the metrics served, when nil
+	// the default Prometheus registry is used.
+	Gatherer prometheus.Gatherer
+
+	// ReadTimeout and WriteTimeout default to 500ms when not set.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// OpenMetrics serves the OpenMetrics format to scrapers which ask
+	// for it, which is needed for exemplars.
+	OpenMetrics bool
 }
 
 // Register binds a HTTP server to expose Prometheus metrics
@@ -25,10 +40,29 @@ func (m *MetricsServer) Register(metricsPort int) {
 	m.port = metricsPort
 
 	readTimeout := time.Millisecond * 500
+	if m.ReadTimeout > 0 {
+		readTimeout = m.ReadTimeout
+	}
+
 	writeTimeout := time.Millisecond * 500
+	if m.WriteTimeout > 0 {
+		writeTimeout = m.WriteTimeout
+	}
+
+	gatherer := m.Gatherer
+	if gatherer == nil {
+		gatherer = prometheus.DefaultGatherer
+	}
+
+	handler := promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{
+		EnableOpenMetrics: m.OpenMetrics,
+	})
+	if m.Gatherer == nil {
+		handler = promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, handler)
+	}
 
 	metricsMux := http.NewServeMux()
-	metricsMux.Handle("/metrics", promhttp.Handler())
+	metricsMux.Handle("/metrics", handler)
 
 	m.s = &http.Server{
 		Addr:           fmt.Sprintf(":%d", metricsPort),
@@ -40,12 +74,31 @@ func (m *MetricsServer) Register(metricsPort int) {
 
 }
 
+// Listen binds the metrics port ahead of Serve, so that an error such
+// as the port already being in use can be returned to the caller.
+func (m *MetricsServer) Listen() error {
+	l, err := net.Listen("tcp", m.s.Addr)
+	if err != nil {
+		return fmt.Errorf("metrics server unable to listen on port %d: %w", m.port, err)
+	}
+
+	m.listener = l
+	return nil
+}
+
 // Serve http traffic in go routine, non-blocking
 func (m *MetricsServer) Serve(cancel chan bool) {
 	log.Printf("Metrics listening on port: %d\n", m.port)
 
 	go func() {
-		if err := m.s.ListenAndServe(); err != http.ErrServerClosed {
+		var err error
+		if m.listener != nil {
+			err = m.s.Serve(m.listener)
+		} else {
+			err = m.s.ListenAndServe()
+		}
+
+		if err != http.ErrServerClosed {
 			panic(fmt.Sprintf("metrics error ListenAndServe: %v\n", err))
 		}
 	}()
@@ -60,16 +113,49 @@ func (m *MetricsServer) Serve(cancel chan bool) {
 	}()
 }
 
+type routeKey struct{}
+
 // InstrumentHandler returns a handler which records HTTP requests
 // as they are made
 func InstrumentHandler(next http.Handler, _http Http) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		then := promhttp.InstrumentHandlerCounter(_http.RequestsTotal,
-			promhttp.InstrumentHandlerDuration(_http.RequestDurationHistogram, next))
+	var opts []promhttp.Option
+	if _http.exemplars {
+		opts = append(opts, promhttp.WithExemplarFromContext(exemplarLabels))
+	}
+	if _http.routes != nil {
+		opts = append(opts, promhttp.WithLabelFromCtx("path", func(ctx context.Context) string {
+			route, _ := ctx.Value(routeKey{}).(string)
+			return route
+		}))
+	}
 
+	then := promhttp.InstrumentHandlerCounter(_http.RequestsTotal,
+		promhttp.InstrumentHandlerDuration(_http.RequestDurationHistogram,
+			promhttp.InstrumentHandlerRequestSize(_http.RequestSizeHistogram,
+				promhttp.InstrumentHandlerResponseSize(_http.ResponseSizeHistogram, next, opts...),
+				opts...),
+			opts...),
+		opts...)
+
+	return func(w http.ResponseWriter, r *http.Request) {
 		_http.InFlight.Inc()
-		defer _http.InFlight.Dec()
+		_http.load.started()
+		defer func() {
+			_http.InFlight.Dec()
+			_http.load.finished()
+		}()
+
+		if _http.routes != nil {
+			r = r.WithContext(context.WithValue(r.Context(), routeKey{}, _http.routes.Match(r.URL.Path)))
+		}
+		if _http.exemplars {
+			r = r.WithContext(context.WithValue(r.Context(), exemplarKey{}, &exemplar{header: r.Header}))
+		}
 
 		then(w, r)
+
+		if r.Context().Err() == context.Canceled {
+			_http.ClientCancellations.Inc()
+		}
 	}
 }
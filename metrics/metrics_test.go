@@ -4,10 +4,15 @@
 package metrics
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func Test_Register_ProvidesBytes(t *testing.T) {
@@ -58,3 +63,138 @@ func Test_Register_ProvidesBytes(t *testing.T) {
 	t.Errorf("unable to get expected response from metrics server")
 	t.Fail()
 }
+
+func Test_InstrumentHandler_PathLabel(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	h := NewHttp(reg, HttpOptions{Routes: NewRoutes([]string{"/users/{id}"})})
+
+	handler := InstrumentHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}), h)
+
+	for _, path := range []string{"/users/1", "/users/2", "/admin"} {
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	if got := testutil.ToFloat64(h.RequestsTotal.WithLabelValues("200", "get", "/users/{id}")); got != 2 {
+		t.Errorf("want 2 requests for /users/{id}, got: %f", got)
+	}
+	if got := testutil.ToFloat64(h.RequestsTotal.WithLabelValues("200", "get", RouteOther)); got != 1 {
+		t.Errorf("want 1 request for %s, got: %f", RouteOther, got)
+	}
+
+	if got := testutil.CollectAndCount(h.ResponseSizeHistogram); got != 2 {
+		t.Errorf("want response sizes for 2 paths, got: %d", got)
+	}
+}
+
+func Test_NewHttp_SharedRegistry(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	a := NewHttp(reg, HttpOptions{})
+	b := NewHttp(reg, HttpOptions{})
+
+	a.ClientCancellations.Inc()
+
+	if got := testutil.ToFloat64(b.ClientCancellations); got != 1 {
+		t.Errorf("want the counter already registered to be reused, got: %f", got)
+	}
+}
+
+func Test_InstrumentHandler_ClientCancellation(t *testing.T) {
+	h := NewHttp(prometheus.NewRegistry(), HttpOptions{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	handler := InstrumentHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		<-r.Context().Done()
+	}), h)
+
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+
+	if got := testutil.ToFloat64(h.ClientCancellations); got != 1 {
+		t.Errorf("want 1 client cancellation, got: %f", got)
+	}
+}
+
+func Test_InstrumentHandler_Exemplars(t *testing.T) {
+	cases := []struct {
+		name    string
+		traceID string
+		callID  string
+		want    prometheus.Labels
+	}{
+		{name: "trace ID is preferred", traceID: "4bf92f3577b34da6a3ce929d0e0e4736", callID: "abc", want: prometheus.Labels{"trace_id": "4bf92f3577b34da6a3ce929d0e0e4736"}},
+		{name: "call ID without a trace", callID: "abc", want: prometheus.Labels{"call_id": "abc"}},
+		{name: "no IDs", want: nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			h := NewHttp(reg, HttpOptions{Exemplars: true})
+
+			handler := InstrumentHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// The call ID and trace are only known once the request is handled.
+				if len(tc.callID) > 0 {
+					r.Header.Set("X-Call-Id", tc.callID)
+				}
+				if len(tc.traceID) > 0 {
+					SetTraceID(r.Context(), tc.traceID)
+				}
+			}), h)
+
+			handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			families, err := reg.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got prometheus.Labels
+			for _, family := range families {
+				if family.GetName() != "http_requests_total" {
+					continue
+				}
+				if exemplar := family.GetMetric()[0].GetCounter().GetExemplar(); exemplar != nil {
+					got = prometheus.Labels{}
+					for _, label := range exemplar.GetLabel() {
+						got[label.GetName()] = label.GetValue()
+					}
+				}
+			}
+
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("want exemplar: %v, got: %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func Test_NewHttp_BucketsAndNativeHistograms(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	h := NewHttp(reg, HttpOptions{Buckets: []float64{0.1, 1}, NativeHistograms: true})
+
+	h.RequestDurationHistogram.WithLabelValues("200", "get").Observe(0.5)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, family := range families {
+		if family.GetName() != "http_request_duration_seconds" {
+			continue
+		}
+
+		histogram := family.GetMetric()[0].GetHistogram()
+		if got := len(histogram.GetBucket()); got != 2 {
+			t.Errorf("want 2 classic buckets, got: %d", got)
+		}
+		if histogram.GetSchema() == 0 && histogram.GetZeroThreshold() == 0 {
+			t.Errorf("want a native histogram")
+		}
+		return
+	}
+
+	t.Errorf("http_request_duration_seconds not gathered")
+}
@@ -0,0 +1,287 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// userHZ is the unit of the CPU times in /proc/<pid>/stat, which is
+// 100 on all of the architectures supported by Linux.
+const userHZ = 100
+
+// ProcUsage is the resource usage of a process and all of its
+// descendants.
+type ProcUsage struct {
+	// Processes is the number of processes in the tree.
+	Processes int
+
+	CPUSeconds  float64
+	RSSBytes    int64
+	Threads     int
+	OpenFDs     int
+	StartedTime time.Time
+}
+
+// ProcCollector reads the resource usage of the function's process tree
+// from procfs when scraped.
+type ProcCollector struct {
+	root string
+	pid  func() int
+	now  func() time.Time
+
+	processes *prometheus.Desc
+	cpu       *prometheus.Desc
+	rss       *prometheus.Desc
+	threads   *prometheus.Desc
+	fds       *prometheus.Desc
+	uptime    *prometheus.Desc
+}
+
+// NewProcCollector reads from the procfs mounted at root, usually /proc,
+// for the process returned by pid. Nothing is collected whilst pid
+// returns 0, i.e. before the process has started.
+func NewProcCollector(root string, pid func() int, now func() time.Time) *ProcCollector {
+	return &ProcCollector{
+		root: root,
+		pid:  pid,
+		now:  now,
+
+		processes: prometheus.NewDesc("function_process_count",
+			"Processes in the function's process tree.", nil, nil),
+		cpu: prometheus.NewDesc("function_process_cpu_seconds_total",
+			"User and system CPU time spent by the function's process tree in seconds.", nil, nil),
+		rss: prometheus.NewDesc("function_process_resident_memory_bytes",
+			"Resident memory size of the function's process tree in bytes.", nil, nil),
+		threads: prometheus.NewDesc("function_process_threads",
+			"Threads in the function's process tree.", nil, nil),
+		fds: prometheus.NewDesc("function_process_open_fds",
+			"Open file descriptors in the function's process tree.", nil, nil),
+		uptime: prometheus.NewDesc("function_process_uptime_seconds",
+			"Seconds since the function process started.", nil, nil),
+	}
+}
+
+// Describe sends the descriptors of the process metrics.
+func (c *ProcCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.processes
+	ch <- c.cpu
+	ch <- c.rss
+	ch <- c.threads
+	ch <- c.fds
+	ch <- c.uptime
+}
+
+// Collect sends the process metrics, or nothing when the process is
+// not running or procfs can't be read.
+func (c *ProcCollector) Collect(ch chan<- prometheus.Metric) {
+	usage, err := c.Usage()
+	if err != nil {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.processes, prometheus.GaugeValue, float64(usage.Processes))
+	ch <- prometheus.MustNewConstMetric(c.cpu, prometheus.CounterValue, usage.CPUSeconds)
+	ch <- prometheus.MustNewConstMetric(c.rss, prometheus.GaugeValue, float64(usage.RSSBytes))
+	ch <- prometheus.MustNewConstMetric(c.threads, prometheus.GaugeValue, float64(usage.Threads))
+	ch <- prometheus.MustNewConstMetric(c.fds, prometheus.GaugeValue, float64(usage.OpenFDs))
+	ch <- prometheus.MustNewConstMetric(c.uptime, prometheus.GaugeValue, c.now().Sub(usage.StartedTime).Seconds())
+}
+
+// Usage reads the resource usage of the process and its descendants.
+func (c *ProcCollector) Usage() (ProcUsage, error) {
+	usage := ProcUsage{}
+
+	pid := c.pid()
+	if pid <= 0 {
+		return usage, fmt.Errorf("the function process is not running")
+	}
+
+	root, err := c.readStat(pid)
+	if err != nil {
+		return usage, err
+	}
+
+	bootTime, err := c.bootTime()
+	if err != nil {
+		return usage, err
+	}
+	usage.StartedTime = bootTime.Add(time.Duration(root.startTicks) * time.Second / userHZ)
+
+	for _, p := range c.tree(pid) {
+		stat, err := c.readStat(p)
+		if err != nil {
+			// The process may have exited since the tree was read.
+			continue
+		}
+
+		status, err := c.readStatus(p)
+		if err != nil {
+			continue
+		}
+
+		usage.Processes++
+		usage.CPUSeconds += float64(stat.utime+stat.stime) / userHZ
+		usage.RSSBytes += status.rssBytes
+		usage.Threads += status.threads
+
+		if fds, err := os.ReadDir(filepath.Join(c.root, strconv.Itoa(p), "fd")); err == nil {
+			usage.OpenFDs += len(fds)
+		}
+	}
+
+	return usage, nil
+}
+
+// tree returns pid and the PIDs of all of its descendants.
+func (c *ProcCollector) tree(pid int) []int {
+	entries, err := os.ReadDir(c.root)
+	if err != nil {
+		return []int{pid}
+	}
+
+	children := map[int][]int{}
+	for _, entry := range entries {
+		child, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+
+		if stat, err := c.readStat(child); err == nil {
+			children[stat.ppid] = append(children[stat.ppid], child)
+		}
+	}
+
+	pids := []int{pid}
+	for i := 0; i < len(pids); i++ {
+		pids = append(pids, children[pids[i]]...)
+	}
+
+	return pids
+}
+
+type procStat struct {
+	ppid       int
+	utime      uint64
+	stime      uint64
+	startTicks uint64
+}
+
+// readStat parses /proc/<pid>/stat, where the command name in brackets
+// may itself contain spaces or brackets.
+func (c *ProcCollector) readStat(pid int) (procStat, error) {
+	stat := procStat{}
+
+	data, err := os.ReadFile(filepath.Join(c.root, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return stat, err
+	}
+
+	end := strings.LastIndexByte(string(data), ')')
+	if end < 0 {
+		return stat, fmt.Errorf("invalid stat for pid %d", pid)
+	}
+
+	// The fields after the command name start with the state, which is
+	// the third field of the file.
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 20 {
+		return stat, fmt.Errorf("invalid stat for pid %d", pid)
+	}
+
+	if stat.ppid, err = strconv.Atoi(fields[1]); err != nil {
+		return stat, err
+	}
+	if stat.utime, err = strconv.ParseUint(fields[11], 10, 64); err != nil {
+		return stat, err
+	}
+	if stat.stime, err = strconv.ParseUint(fields[12], 10, 64); err != nil {
+		return stat, err
+	}
+	if stat.startTicks, err = strconv.ParseUint(fields[19], 10, 64); err != nil {
+		return stat, err
+	}
+
+	return stat, nil
+}
+
+type procStatus struct {
+	rssBytes int64
+	threads  int
+}
+
+func (c *ProcCollector) readStatus(pid int) (procStatus, error) {
+	status := procStatus{}
+
+	f, err := os.Open(filepath.Join(c.root, strconv.Itoa(pid), "status"))
+	if err != nil {
+		return status, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch key {
+		case "VmRSS":
+			// VmRSS is given in kB, and is absent for a zombie.
+			kb, err := strconv.ParseInt(fields[0], 10, 64)
+			if err != nil {
+				return status, err
+			}
+			status.rssBytes = kb * 1024
+		case "Threads":
+			if status.threads, err = strconv.Atoi(fields[0]); err != nil {
+				return status, err
+			}
+		}
+	}
+
+	return status, scanner.Err()
+}
+
+// bootTime reads btime from /proc/stat, which the start time of each
+// process is relative to.
+func (c *ProcCollector) bootTime() (time.Time, error) {
+	f, err := os.Open(filepath.Join(c.root, "stat"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "btime" {
+			seconds, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(seconds, 0), nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Time{}, fmt.Errorf("btime not found in %s", filepath.Join(c.root, "stat"))
+}
@@ -0,0 +1,110 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package metrics
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// writeFakeProc adds a process to a fake procfs at root.
+func writeFakeProc(t *testing.T, root string, pid, ppid int, utime, stime, startTicks, rssKB, threads, fds int) {
+	t.Helper()
+
+	dir := filepath.Join(root, strconv.Itoa(pid))
+	if err := os.MkdirAll(filepath.Join(dir, "fd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// The command name has a space and a bracket, as a real one may.
+	stat := fmt.Sprintf("%d (my) fn) S %d 1 1 0 -1 4194560 100 0 0 0 %d %d 0 0 20 0 %d 0 %d 1000 200\n",
+		pid, ppid, utime, stime, threads, startTicks)
+	status := fmt.Sprintf("Name:\tfn\nState:\tS (sleeping)\nVmRSS:\t    %d kB\nThreads:\t%d\n", rssKB, threads)
+
+	if err := os.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "status"), []byte(status), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < fds; i++ {
+		if err := os.WriteFile(filepath.Join(dir, "fd", strconv.Itoa(i)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestProcCollector_SumsProcessTree(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "stat"), []byte("cpu  1 2 3 4\nbtime 1700000000\nprocesses 10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFakeProc(t, root, 100, 1, 150, 50, 1000, 2048, 4, 5)
+	writeFakeProc(t, root, 101, 100, 100, 0, 1100, 1024, 1, 3)
+	writeFakeProc(t, root, 102, 101, 0, 100, 1200, 1024, 1, 1)
+	writeFakeProc(t, root, 200, 1, 500, 500, 900, 8192, 8, 10)
+
+	now := time.Unix(1700000000+10+60, 0)
+	c := NewProcCollector(root, func() int { return 100 }, func() time.Time { return now })
+
+	usage, err := c.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := ProcUsage{
+		Processes:   3,
+		CPUSeconds:  4,
+		RSSBytes:    4096 * 1024,
+		Threads:     6,
+		OpenFDs:     9,
+		StartedTime: time.Unix(1700000000+10, 0),
+	}
+	if usage != want {
+		t.Errorf("want usage: %+v, got: %+v", want, usage)
+	}
+
+	if got := testutil.CollectAndCount(c); got != 6 {
+		t.Errorf("want 6 metrics, got: %d", got)
+	}
+	if got := testutil.CollectAndCount(c, "function_process_uptime_seconds"); got != 1 {
+		t.Errorf("want uptime collected, got: %d", got)
+	}
+}
+
+func TestProcCollector_NothingBeforeStart(t *testing.T) {
+	c := NewProcCollector(t.TempDir(), func() int { return 0 }, time.Now)
+
+	if got := testutil.CollectAndCount(c); got != 0 {
+		t.Errorf("want no metrics before the process starts, got: %d", got)
+	}
+}
+
+func TestProcCollector_ReadsProcfs(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("procfs is not mounted")
+	}
+
+	c := NewProcCollector("/proc", os.Getpid, time.Now)
+
+	usage, err := c.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if usage.Processes < 1 || usage.RSSBytes <= 0 || usage.Threads < 1 || usage.OpenFDs < 1 {
+		t.Errorf("want usage of the test process, got: %+v", usage)
+	}
+	if usage.StartedTime.After(time.Now()) {
+		t.Errorf("want a start time in the past, got: %s", usage.StartedTime)
+	}
+}
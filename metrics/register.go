@@ -0,0 +1,63 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package metrics
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// factory creates and registers collectors like promauto, except that a
+// collector which is already registered, such as by another watchdog
+// sharing the registry, is returned in place of the new one.
+type factory struct {
+	reg prometheus.Registerer
+}
+
+func with(reg prometheus.Registerer) factory {
+	return factory{reg: reg}
+}
+
+// register adds c to reg, returning the collector registered before it
+// when there is one. Any other error is a programming error, so panics.
+func register[T prometheus.Collector](reg prometheus.Registerer, c T) T {
+	err := reg.Register(c)
+	if err == nil {
+		return c
+	}
+
+	var already prometheus.AlreadyRegisteredError
+	if errors.As(err, &already) {
+		if existing, ok := already.ExistingCollector.(T); ok {
+			return existing
+		}
+	}
+
+	panic(err)
+}
+
+func (f factory) NewCounter(opts prometheus.CounterOpts) prometheus.Counter {
+	return register(f.reg, prometheus.NewCounter(opts))
+}
+
+func (f factory) NewCounterVec(opts prometheus.CounterOpts, labelNames []string) *prometheus.CounterVec {
+	return register(f.reg, prometheus.NewCounterVec(opts, labelNames))
+}
+
+func (f factory) NewGauge(opts prometheus.GaugeOpts) prometheus.Gauge {
+	return register(f.reg, prometheus.NewGauge(opts))
+}
+
+func (f factory) NewGaugeVec(opts prometheus.GaugeOpts, labelNames []string) *prometheus.GaugeVec {
+	return register(f.reg, prometheus.NewGaugeVec(opts, labelNames))
+}
+
+func (f factory) NewHistogram(opts prometheus.HistogramOpts) prometheus.Histogram {
+	return register(f.reg, prometheus.NewHistogram(opts))
+}
+
+func (f factory) NewHistogramVec(opts prometheus.HistogramOpts, labelNames []string) *prometheus.HistogramVec {
+	return register(f.reg, prometheus.NewHistogramVec(opts, labelNames))
+}
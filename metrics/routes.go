@@ -0,0 +1,70 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package metrics
+
+import "strings"
+
+// RouteOther is the path label for a request which matches none of
+// the route templates.
+const RouteOther = "other"
+
+// Routes map request paths onto a fixed set of route templates, so that
+// the path label cannot grow without bound.
+//
+// A template such as "/users/{id}/orders" matches a path segment by
+// segment, where "{id}" matches any one segment. A final segment such
+// as "{rest...}" matches the remainder of the path.
+type Routes struct {
+	templates []string
+	segments  [][]string
+}
+
+// NewRoutes creates Routes from templates, which are tried in order.
+func NewRoutes(templates []string) *Routes {
+	r := &Routes{}
+	for _, template := range templates {
+		r.templates = append(r.templates, template)
+		r.segments = append(r.segments, strings.Split(strings.Trim(template, "/"), "/"))
+	}
+
+	return r
+}
+
+// Match returns the first template which matches path, or RouteOther.
+func (r *Routes) Match(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	for i, segments := range r.segments {
+		if matchSegments(segments, parts) {
+			return r.templates[i]
+		}
+	}
+
+	return RouteOther
+}
+
+func matchSegments(segments, parts []string) bool {
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "...}") {
+			return true
+		}
+
+		if i >= len(parts) {
+			return false
+		}
+
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if len(parts[i]) == 0 {
+				return false
+			}
+			continue
+		}
+
+		if segment != parts[i] {
+			return false
+		}
+	}
+
+	return len(segments) == len(parts)
+}
@@ -0,0 +1,32 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package metrics
+
+import "testing"
+
+func TestRoutes_Match(t *testing.T) {
+	routes := NewRoutes([]string{"/", "/users/{id}", "/users/{id}/orders", "/static/{path...}"})
+
+	cases := []struct {
+		path string
+		want string
+	}{
+		{path: "/", want: "/"},
+		{path: "/users/42", want: "/users/{id}"},
+		{path: "/users/42/", want: "/users/{id}"},
+		{path: "/users/42/orders", want: "/users/{id}/orders"},
+		{path: "/users", want: RouteOther},
+		{path: "/users//orders", want: RouteOther},
+		{path: "/static/css/site.css", want: "/static/{path...}"},
+		{path: "/admin", want: RouteOther},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.path, func(t *testing.T) {
+			if got := routes.Match(tc.path); got != tc.want {
+				t.Errorf("want route: %q, got: %q", tc.want, got)
+			}
+		})
+	}
+}
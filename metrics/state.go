@@ -0,0 +1,28 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NewStateGauge creates a gauge with a series for each lifecycle state
+// of the watchdog, where the current state has a value of 1.
+func NewStateGauge(reg prometheus.Registerer) *prometheus.GaugeVec {
+	return with(reg).NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "watchdog",
+		Name:      "state",
+		Help:      "current lifecycle state of the watchdog",
+	}, []string{"state"})
+}
+
+// NewTimeToReadyGauge creates a gauge for the seconds the watchdog took
+// to become ready, including any warm-up requests.
+func NewTimeToReadyGauge(reg prometheus.Registerer) prometheus.Gauge {
+	return with(reg).NewGauge(prometheus.GaugeOpts{
+		Subsystem: "watchdog",
+		Name:      "time_to_ready_seconds",
+		Help:      "Seconds from the watchdog starting until it was ready, including warm-up.",
+	})
+}
@@ -0,0 +1,44 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// StatsD records the custom metrics received from the function over
+// StatsD, but not the metrics themselves.
+type StatsD struct {
+	// Samples counts the lines which were recorded.
+	Samples prometheus.Counter
+
+	// Dropped counts the lines which were not recorded, by reason.
+	Dropped *prometheus.CounterVec
+
+	// Series is the number of series being kept.
+	Series prometheus.Gauge
+}
+
+// NewStatsD creates the StatsD metrics and registers them with reg.
+func NewStatsD(reg prometheus.Registerer) *StatsD {
+	factory := with(reg)
+
+	return &StatsD{
+		Samples: factory.NewCounter(prometheus.CounterOpts{
+			Subsystem: "statsd",
+			Name:      "samples_total",
+			Help:      "total StatsD lines recorded from the function",
+		}),
+		Dropped: factory.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: "statsd",
+			Name:      "samples_dropped_total",
+			Help:      "total StatsD lines from the function which were dropped",
+		}, []string{"reason"}),
+		Series: factory.NewGauge(prometheus.GaugeOpts{
+			Subsystem: "statsd",
+			Name:      "series",
+			Help:      "series of custom metrics from the function being kept",
+		}),
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	units "github.com/docker/go-units"
+	"github.com/openfaas/faas-provider/httputil"
+	"github.com/openfaas/of-watchdog/config"
+)
+
+type accessLogKey struct{}
+
+// accessLogEntry collects the parts of the access log which are only
+// known deep inside the function handler.
+type accessLogEntry struct {
+	err error
+}
+
+// recordAccessLogError adds err to the access log line for the request,
+// if one is being written.
+func recordAccessLogError(ctx context.Context, err error) {
+	if entry, ok := ctx.Value(accessLogKey{}).(*accessLogEntry); ok {
+		entry.err = err
+	}
+}
+
+// newAccessLogger creates a logger writing to out in the given format.
+func newAccessLogger(out io.Writer, format string, logCallId bool) *slog.Logger {
+	switch format {
+	case config.AccessLogJSON:
+		return slog.New(slog.NewJSONHandler(out, nil))
+	case config.AccessLogLogfmt:
+		return slog.New(slog.NewTextHandler(out, nil))
+	default:
+		return slog.New(&textAccessLogHandler{
+			logger:    log.New(out, "", log.LstdFlags),
+			logCallId: logCallId,
+		})
+	}
+}
+
+// makeAccessLogHandler writes one access log line for each request
+// with a fixed set of fields, whatever the mode.
+func makeAccessLogHandler(next http.Handler, logger *slog.Logger, mode string, skipUserAgents []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, agent := range skipUserAgents {
+			if strings.HasPrefix(r.UserAgent(), agent) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		start := time.Now()
+
+		// Only wrap a body which is present, so that a request without
+		// one is proxied without one.
+		body := &countingReader{ReadCloser: r.Body}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = body
+		}
+
+		entry := &accessLogEntry{}
+		ww := httputil.NewHttpWriteInterceptor(w)
+		next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), accessLogKey{}, entry)))
+
+		errText := ""
+		if entry.err != nil {
+			errText = entry.err.Error()
+		}
+
+		logger.LogAttrs(r.Context(), slog.LevelInfo, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", ww.Status()),
+			slog.Int64("bytes_in", atomic.LoadInt64(&body.n)),
+			slog.Int64("bytes_out", ww.BytesWritten()),
+			slog.Float64("duration", time.Since(start).Seconds()),
+			slog.String("call_id", r.Header.Get("X-Call-Id")),
+			slog.String("user_agent", r.UserAgent()),
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.String("mode", mode),
+			slog.String("error", errText),
+		)
+	})
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+// textAccessLogHandler formats the access log in the same way as the
+// watchdog always has, with the call ID in brackets when logCallId is set.
+type textAccessLogHandler struct {
+	logger    *log.Logger
+	logCallId bool
+}
+
+func (h *textAccessLogHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *textAccessLogHandler) Handle(_ context.Context, record slog.Record) error {
+	fields := map[string]slog.Value{}
+	record.Attrs(func(attr slog.Attr) bool {
+		fields[attr.Key] = attr.Value
+		return true
+	})
+
+	line := fmt.Sprintf("%s %s - %d - ContentLength: %s (%.4fs)",
+		fields["method"].String(),
+		fields["path"].String(),
+		fields["status"].Int64(),
+		units.HumanSize(float64(fields["bytes_out"].Int64())),
+		fields["duration"].Float64())
+
+	if errText := fields["error"].String(); len(errText) > 0 {
+		line += " - " + errText
+	}
+
+	if h.logCallId {
+		callId := fields["call_id"].String()
+		if callId == "" {
+			callId = "none"
+		}
+		line += " [" + callId + "]"
+	}
+
+	h.logger.Println(line)
+	return nil
+}
+
+func (h *textAccessLogHandler) WithAttrs([]slog.Attr) slog.Handler {
+	return h
+}
+
+func (h *textAccessLogHandler) WithGroup(string) slog.Handler {
+	return h
+}
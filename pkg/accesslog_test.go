@@ -0,0 +1,104 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openfaas/of-watchdog/config"
+)
+
+func TestAccessLog_JSONHasFixedFields(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := newAccessLogger(out, config.AccessLogJSON, false)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		recordAccessLogError(r.Context(), fmt.Errorf("exit status 1"))
+
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "failed")
+	})
+	handler := makeAccessLogHandler(next, logger, "serializing", []string{"kube-probe"})
+
+	req := httptest.NewRequest(http.MethodPost, "/function?q=1", strings.NewReader("hello"))
+	req.Header.Set("X-Call-Id", "abc")
+	req.Header.Set("User-Agent", "curl/8.0")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	line := map[string]interface{}{}
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("want one JSON line, got: %q, error: %s", out.String(), err)
+	}
+
+	want := map[string]interface{}{
+		"method":      http.MethodPost,
+		"path":        "/function",
+		"status":      float64(http.StatusInternalServerError),
+		"bytes_in":    float64(5),
+		"bytes_out":   float64(6),
+		"call_id":     "abc",
+		"user_agent":  "curl/8.0",
+		"remote_addr": req.RemoteAddr,
+		"mode":        "serializing",
+		"error":       "exit status 1",
+	}
+	for key, value := range want {
+		if line[key] != value {
+			t.Errorf("want %s: %v, got: %v", key, value, line[key])
+		}
+	}
+
+	if _, ok := line["duration"]; !ok {
+		t.Errorf("want duration field, got: %v", line)
+	}
+}
+
+func TestAccessLog_TextFormat(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := newAccessLogger(out, config.AccessLogText, true)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	})
+	handler := makeAccessLogHandler(next, logger, "http", nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	got := out.String()
+	if !strings.Contains(got, "GET / - 200 - ContentLength: 2B") || !strings.HasSuffix(got, "[none]\n") {
+		t.Errorf("want text access log with call ID, got: %q", got)
+	}
+}
+
+func TestAccessLog_SkipsUserAgents(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := newAccessLogger(out, config.AccessLogLogfmt, false)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := makeAccessLogHandler(next, logger, "http", []string{"kube-probe"})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("User-Agent", "kube-probe/1.29")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if out.Len() > 0 {
+		t.Errorf("want no access log for kube-probe, got: %q", out.String())
+	}
+
+	req.Header.Set("User-Agent", "curl/8.0")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !strings.Contains(out.String(), "status=200") {
+		t.Errorf("want logfmt access log, got: %q", out.String())
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/openfaas/faas-provider/httputil"
+	"github.com/openfaas/of-watchdog/metrics"
+)
+
+const (
+	// adaptiveBackoff is the ratio the limit is multiplied by when
+	// latency or errors show the function is overloaded.
+	adaptiveBackoff = 0.9
+
+	// adaptiveErrorRate is the fraction of failed requests in a window
+	// above which the limit is reduced.
+	adaptiveErrorRate = 0.1
+
+	// adaptiveBaselineDrift is how quickly the baseline latency moves up
+	// towards the observed latency, so that it can recover from a
+	// single unusually fast window.
+	adaptiveBaselineDrift = 0.01
+)
+
+// adaptiveLimiter limits requests in-flight, using an additive increase
+// multiplicative decrease (AIMD) algorithm to find the limit.
+//
+// Latency and failures are collected over a window. At the end of each
+// window the limit is reduced by adaptiveBackoff if the average latency
+// exceeds the baseline by more than the tolerance, or too many requests
+// failed. Otherwise, if the limit was reached during the window, it is
+// increased by one. The baseline is the lowest average latency seen.
+type adaptiveLimiter struct {
+	next      http.Handler
+	now       func() time.Time
+	minLimit  int
+	maxLimit  int
+	window    time.Duration
+	tolerance float64
+	metrics   *metrics.Http
+
+	mu          sync.Mutex
+	limit       int
+	inflight    int
+	windowStart time.Time
+	samples     int
+	failures    int
+	latencySum  time.Duration
+	baseline    time.Duration
+	saturated   bool
+}
+
+func newAdaptiveLimiter(next http.Handler, initial, minLimit, maxLimit int, window time.Duration, tolerance float64, httpMetrics *metrics.Http, now func() time.Time) *adaptiveLimiter {
+	if now == nil {
+		now = time.Now
+	}
+
+	a := &adaptiveLimiter{
+		next:        next,
+		now:         now,
+		minLimit:    minLimit,
+		maxLimit:    maxLimit,
+		window:      window,
+		tolerance:   tolerance,
+		metrics:     httpMetrics,
+		limit:       clamp(initial, minLimit, maxLimit),
+		windowStart: now(),
+	}
+
+	a.setLimitMetric()
+	return a
+}
+
+// Met returns true when the current limit has been reached.
+func (a *adaptiveLimiter) Met() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.inflight >= a.limit
+}
+
+// Limit returns the current limit.
+func (a *adaptiveLimiter) Limit() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.limit
+}
+
+func (a *adaptiveLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	if a.inflight >= a.limit {
+		a.saturated = true
+		limit := a.limit
+		a.mu.Unlock()
+
+		countRejection(a.metrics, "adaptive")
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Add("X-OpenFaaS-Internal", "of-watchdog")
+		w.WriteHeader(http.StatusTooManyRequests)
+
+		fmt.Fprintf(w, "Concurrent request limit exceeded. Max concurrent requests: %d\n", limit)
+		return
+	}
+
+	a.inflight++
+	if a.inflight >= a.limit {
+		a.saturated = true
+	}
+	a.mu.Unlock()
+
+	start := a.now()
+	ww := httputil.NewHttpWriteInterceptor(w)
+
+	defer func() {
+		a.observe(a.now().Sub(start), ww.Status() >= http.StatusInternalServerError)
+	}()
+
+	a.next.ServeHTTP(ww, r)
+}
+
+// observe records a completed request, and updates the limit
+// if the window has elapsed.
+func (a *adaptiveLimiter) observe(latency time.Duration, failed bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.inflight--
+	a.samples++
+	a.latencySum += latency
+	if failed {
+		a.failures++
+	}
+
+	now := a.now()
+	if now.Sub(a.windowStart) < a.window {
+		return
+	}
+
+	a.update()
+
+	a.windowStart = now
+	a.samples = 0
+	a.failures = 0
+	a.latencySum = 0
+	a.saturated = a.inflight >= a.limit
+}
+
+// update must be called with the lock held.
+func (a *adaptiveLimiter) update() {
+	average := a.latencySum / time.Duration(a.samples)
+
+	switch {
+	case a.baseline == 0 || average < a.baseline:
+		a.baseline = average
+	default:
+		a.baseline += time.Duration(adaptiveBaselineDrift * float64(average-a.baseline))
+	}
+
+	overloaded := float64(average) > float64(a.baseline)*a.tolerance ||
+		float64(a.failures)/float64(a.samples) > adaptiveErrorRate
+
+	switch {
+	case overloaded:
+		a.limit = clamp(int(math.Floor(float64(a.limit)*adaptiveBackoff)), a.minLimit, a.maxLimit)
+	case a.saturated:
+		a.limit = clamp(a.limit+1, a.minLimit, a.maxLimit)
+	}
+
+	a.setLimitMetric()
+}
+
+func (a *adaptiveLimiter) setLimitMetric() {
+	if a.metrics != nil {
+		a.metrics.InFlightLimit.Set(float64(a.limit))
+	}
+}
+
+// clamp keeps v between min and max, and never lets it fall below 1,
+// at which no requests would be admitted to move the limit again.
+func clamp(v, min, max int) int {
+	if min < 1 {
+		min = 1
+	}
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
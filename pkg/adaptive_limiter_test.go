@@ -0,0 +1,149 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openfaas/of-watchdog/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// latencyHandler advances the clock by latency and responds with status.
+func latencyHandler(clock *fakeClock, latency *time.Duration, status *int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clock.Advance(*latency)
+		w.WriteHeader(*status)
+	})
+}
+
+func TestAdaptiveLimiter_GrowsOnlyWhenSaturated(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	latency := 100 * time.Millisecond
+	status := http.StatusOK
+
+	httpMetrics := metrics.NewHttp(prometheus.NewRegistry(), metrics.HttpOptions{})
+	a := newAdaptiveLimiter(latencyHandler(clock, &latency, &status), 1, 1, 10, time.Second, 2, &httpMetrics, clock.Now)
+
+	// Requests are served one at a time, so only the first window at
+	// the limit of 1 is saturated.
+	for i := 0; i < 50; i++ {
+		a.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+
+	if got := a.Limit(); got != 2 {
+		t.Errorf("want limit to grow to 2, got: %d", got)
+	}
+
+	if v := testutil.ToFloat64(httpMetrics.InFlightLimit); v != 2 {
+		t.Errorf("want limit metric of 2, got: %f", v)
+	}
+}
+
+func TestAdaptiveLimiter_ShrinksWhenLatencyIncreases(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	latency := 100 * time.Millisecond
+	status := http.StatusOK
+
+	a := newAdaptiveLimiter(latencyHandler(clock, &latency, &status), 20, 1, 100, time.Second, 2, nil, clock.Now)
+
+	// Establish the baseline.
+	for i := 0; i < 10; i++ {
+		a.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	if got := a.Limit(); got != 20 {
+		t.Fatalf("want limit to stay at 20 when not saturated, got: %d", got)
+	}
+
+	latency = 500 * time.Millisecond
+	for i := 0; i < 2; i++ {
+		a.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+
+	if got := a.Limit(); got != 18 {
+		t.Errorf("want limit to back off to 18, got: %d", got)
+	}
+}
+
+func TestAdaptiveLimiter_ShrinksOnErrors(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	latency := 250 * time.Millisecond
+	status := http.StatusBadGateway
+
+	a := newAdaptiveLimiter(latencyHandler(clock, &latency, &status), 10, 5, 100, time.Second, 2, nil, clock.Now)
+
+	for i := 0; i < 40; i++ {
+		a.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+
+	if got := a.Limit(); got != 5 {
+		t.Errorf("want limit to back off to the minimum of 5, got: %d", got)
+	}
+}
+
+func TestAdaptiveLimiter_NeverShrinksBelowOne(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	latency := 250 * time.Millisecond
+	status := http.StatusBadGateway
+
+	a := newAdaptiveLimiter(latencyHandler(clock, &latency, &status), 3, 0, 100, time.Second, 2, nil, clock.Now)
+
+	for i := 0; i < 40; i++ {
+		a.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+
+	if got := a.Limit(); got != 1 {
+		t.Fatalf("want limit to stop at 1, got: %d", got)
+	}
+
+	status = http.StatusOK
+	rr := httptest.NewRecorder()
+	a.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("want a request to be admitted at the floor, got status: %d", rr.Code)
+	}
+}
+
+func TestAdaptiveLimiter_RejectsAndReportsMetAtLimit(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+
+	a := newAdaptiveLimiter(blockingHandler(started, release), 1, 1, 10, time.Second, 2, nil, nil)
+
+	done := serveAsync(a, httptest.NewRequest(http.MethodGet, "/", nil))
+	<-started
+
+	if !a.Met() {
+		t.Error("want limit to be met")
+	}
+
+	rr := httptest.NewRecorder()
+	a.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("want status %d, got: %d", http.StatusTooManyRequests, rr.Code)
+	}
+
+	close(release)
+	<-done
+
+	if a.Met() {
+		t.Error("want limit not to be met after the request completed")
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCallID_UsesHeader(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Call-Id", "079d9ff9")
+
+	if got := callID(r); got != "079d9ff9" {
+		t.Errorf("want call ID from the header, got: %q", got)
+	}
+}
+
+func TestCallID_GeneratesAndSetsHeader(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	got := callID(r)
+	if len(got) == 0 {
+		t.Fatal("want a generated call ID")
+	}
+
+	if r.Header.Get("X-Call-Id") != got {
+		t.Errorf("want generated call ID %q set on the request, got: %q", got, r.Header.Get("X-Call-Id"))
+	}
+}
@@ -0,0 +1,368 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	limiter "github.com/openfaas/faas-middleware/concurrency-limiter"
+	"github.com/openfaas/of-watchdog/config"
+)
+
+const (
+	checkPending = "pending"
+	checkOK      = "ok"
+	checkFail    = "fail"
+)
+
+// maxCheckOutput is the most output of an exec check kept in its error.
+const maxCheckOutput = 256
+
+// healthCheck is one check reported by /_/ready, and also by /_/health
+// when liveness is set.
+type healthCheck struct {
+	name string
+	run  func(ctx context.Context) error
+
+	// liveness adds the check to /_/health.
+	liveness bool
+
+	// status is returned by /_/ready whilst the check fails.
+	status int
+
+	// interval runs the check in the background, with the last result
+	// being reported. When 0, the check is run for each request, so it
+	// must be cheap.
+	interval time.Duration
+	timeout  time.Duration
+
+	// successThreshold and failureThreshold are the consecutive results
+	// needed for the check to pass or fail, both default to 1.
+	successThreshold int
+	failureThreshold int
+
+	mu        sync.Mutex
+	result    checkResult
+	passing   bool
+	successes int
+	failures  int
+}
+
+// checkResult is the last result of a check, as shown by ?verbose.
+type checkResult struct {
+	Name       string     `json:"name"`
+	Status     string     `json:"status"`
+	DurationMs float64    `json:"duration_ms"`
+	CheckedAt  *time.Time `json:"checked_at,omitempty"`
+
+	// LastError is kept once the check passes again, to help explain
+	// a probe which failed.
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// check runs the check and records its result.
+func (c *healthCheck) check(ctx context.Context) checkResult {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := c.run(ctx)
+	end := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.result.Name = c.name
+	c.result.DurationMs = float64(end.Sub(start).Microseconds()) / 1000
+	c.result.CheckedAt = &end
+
+	if err != nil {
+		c.successes = 0
+		c.failures++
+		c.result.LastError = err.Error()
+		c.result.LastErrorAt = &end
+	} else {
+		c.failures = 0
+		c.successes++
+	}
+
+	// A check starts off failing, like a Kubernetes readiness probe.
+	if c.passing && c.failures >= max(c.failureThreshold, 1) {
+		c.passing = false
+	} else if !c.passing && c.successes >= max(c.successThreshold, 1) {
+		c.passing = true
+	}
+
+	c.result.Status = checkFail
+	if c.passing {
+		c.result.Status = checkOK
+	}
+
+	return c.result
+}
+
+// last returns the result of the last run of the check.
+func (c *healthCheck) last() checkResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.result.Status == "" {
+		return checkResult{Name: c.name, Status: checkPending}
+	}
+
+	return c.result
+}
+
+// checkRegistry holds the built-in checks and those from the
+// health_checks_file.
+type checkRegistry struct {
+	checks []*healthCheck
+}
+
+func (r *checkRegistry) add(c *healthCheck) {
+	if c.status == 0 {
+		c.status = http.StatusServiceUnavailable
+	}
+
+	r.checks = append(r.checks, c)
+}
+
+// Run refreshes the background checks on their intervals until ctx is
+// cancelled, starting with all of them at once.
+func (r *checkRegistry) Run(ctx context.Context) {
+	for _, c := range r.checks {
+		if c.interval > 0 {
+			go func(c *healthCheck) {
+				ticker := time.NewTicker(c.interval)
+				defer ticker.Stop()
+
+				for {
+					c.check(ctx)
+
+					select {
+					case <-ctx.Done():
+						return
+					case <-ticker.C:
+					}
+				}
+			}(c)
+		}
+	}
+}
+
+// evaluate returns the results of the liveness checks, or of all of the
+// checks for readiness, along with the status code to respond with. A
+// 503 is preferred over any other failure, such as the limiter's 429.
+func (r *checkRegistry) evaluate(ctx context.Context, liveness bool) (int, []checkResult) {
+	status := http.StatusOK
+	results := []checkResult{}
+
+	for _, c := range r.checks {
+		if liveness && !c.liveness {
+			continue
+		}
+
+		var result checkResult
+		if c.interval > 0 {
+			result = c.last()
+		} else {
+			result = c.check(ctx)
+		}
+		results = append(results, result)
+
+		if result.Status != checkOK && status != http.StatusServiceUnavailable {
+			status = c.status
+			if liveness {
+				status = http.StatusServiceUnavailable
+			}
+		}
+	}
+
+	return status, results
+}
+
+// checkReport is the body of /_/health and /_/ready with ?verbose.
+type checkReport struct {
+	Status string        `json:"status"`
+	Checks []checkResult `json:"checks"`
+}
+
+func writeCheckReport(w http.ResponseWriter, status int, results []checkResult) {
+	report := checkReport{Status: checkOK, Checks: results}
+	if status < 200 || status > 299 {
+		report.Status = checkFail
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+// verbose returns true when the report of each check is requested.
+func verbose(r *http.Request) bool {
+	_, ok := r.URL.Query()["verbose"]
+	return ok
+}
+
+// newBuiltinChecks creates a registry with the checks which the watchdog
+// has always made for /_/health and /_/ready. limit and memory are
+// optional.
+func newBuiltinChecks(acceptingConnections func() bool, lockPresent func() bool, limit limiter.Limiter, memory *memoryGuard) *checkRegistry {
+	r := &checkRegistry{}
+
+	r.add(&healthCheck{
+		name:     "accepting_connections",
+		liveness: true,
+		run: func(context.Context) error {
+			if !acceptingConnections() {
+				return fmt.Errorf("not accepting connections")
+			}
+			return nil
+		},
+	})
+
+	r.add(&healthCheck{
+		name:     "lock_file",
+		liveness: true,
+		run: func(context.Context) error {
+			if !lockPresent() {
+				return fmt.Errorf("lock file not found")
+			}
+			return nil
+		},
+	})
+
+	if memory != nil {
+		r.add(&healthCheck{
+			name: "memory",
+			run: func(context.Context) error {
+				if memory.UnderPressure() {
+					return fmt.Errorf("memory usage is over memory_high_watermark")
+				}
+				return nil
+			},
+		})
+	}
+
+	if limit != nil {
+		r.add(&healthCheck{
+			name:   "limiter",
+			status: http.StatusTooManyRequests,
+			run: func(context.Context) error {
+				if limit.Met() {
+					return fmt.Errorf("concurrency limit met")
+				}
+				return nil
+			},
+		})
+	}
+
+	return r
+}
+
+// upstreamProcessCheck fails once the function process of http mode has
+// exited.
+func upstreamProcessCheck(running func() bool) *healthCheck {
+	return &healthCheck{
+		name:     "upstream_process",
+		liveness: true,
+		run: func(context.Context) error {
+			if !running() {
+				return fmt.Errorf("function process is not running")
+			}
+			return nil
+		},
+	}
+}
+
+// newHealthCheck creates a background check from the health_checks_file.
+func newHealthCheck(cfg config.HealthCheck) *healthCheck {
+	c := &healthCheck{
+		name:     cfg.Name,
+		liveness: cfg.Liveness,
+		interval: cfg.Interval,
+		timeout:  cfg.Timeout,
+	}
+
+	switch cfg.Type {
+	case config.HealthCheckExec:
+		c.run = func(ctx context.Context) error {
+			return execCheck(ctx, cfg.Command)
+		}
+	case config.HealthCheckHTTP:
+		c.run = func(ctx context.Context) error {
+			return httpCheck(ctx, cfg.URL)
+		}
+	case config.HealthCheckTCP:
+		c.run = func(ctx context.Context) error {
+			conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", cfg.Address)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		}
+	case config.HealthCheckFile:
+		c.run = func(context.Context) error {
+			_, err := os.Stat(cfg.Path)
+			return err
+		}
+	}
+
+	return c
+}
+
+func execCheck(ctx context.Context, command string) error {
+	parts := strings.Fields(command)
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, parts[0], parts[1:]...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	if err := cmd.Run(); err != nil {
+		out := strings.TrimSpace(output.String())
+		if len(out) > maxCheckOutput {
+			out = out[:maxCheckOutput]
+		}
+		if len(out) > 0 {
+			return fmt.Errorf("%w: %s", err, out)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func httpCheck(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 399 {
+		return fmt.Errorf("unexpected status: %d", res.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,167 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/openfaas/of-watchdog/config"
+)
+
+func TestHealthCheck_Types(t *testing.T) {
+	dir := t.TempDir()
+	present := filepath.Join(dir, "ready")
+	if err := os.WriteFile(present, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().String()
+	l.Close()
+
+	cases := []struct {
+		name  string
+		check config.HealthCheck
+		want  string
+	}{
+		{name: "file exists", check: config.HealthCheck{Type: config.HealthCheckFile, Path: present}, want: checkOK},
+		{name: "file missing", check: config.HealthCheck{Type: config.HealthCheckFile, Path: filepath.Join(dir, "missing")}, want: checkFail},
+		{name: "exec exits 0", check: config.HealthCheck{Type: config.HealthCheckExec, Command: "true"}, want: checkOK},
+		{name: "exec exits 1", check: config.HealthCheck{Type: config.HealthCheckExec, Command: "false"}, want: checkFail},
+		{name: "http 200", check: config.HealthCheck{Type: config.HealthCheckHTTP, URL: server.URL + "/healthz"}, want: checkOK},
+		{name: "http 500", check: config.HealthCheck{Type: config.HealthCheckHTTP, URL: server.URL + "/"}, want: checkFail},
+		{name: "tcp open", check: config.HealthCheck{Type: config.HealthCheckTCP, Address: server.Listener.Addr().String()}, want: checkOK},
+		{name: "tcp closed", check: config.HealthCheck{Type: config.HealthCheckTCP, Address: closed}, want: checkFail},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.check.Name = tc.name
+			tc.check.Timeout = time.Second
+
+			result := newHealthCheck(tc.check).check(context.Background())
+			if result.Status != tc.want {
+				t.Errorf("want status %s, got: %s, error: %s", tc.want, result.Status, result.LastError)
+			}
+			if tc.want == checkFail && len(result.LastError) == 0 {
+				t.Error("want the error to be reported")
+			}
+		})
+	}
+}
+
+func TestReadiness_UsesCachedResults(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "ready")
+
+	checks := newBuiltinChecks(func() bool { return true }, func() bool { return true }, nil, nil)
+	background := newHealthCheck(config.HealthCheck{
+		Name:     "model",
+		Type:     config.HealthCheckFile,
+		Path:     marker,
+		Interval: time.Hour,
+		Timeout:  time.Second,
+	})
+	checks.add(background)
+
+	handler := &readiness{functionHandler: http.NotFoundHandler(), checks: checks}
+	ready := func() int {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/_/ready", nil))
+		return rr.Code
+	}
+
+	if got := ready(); got != http.StatusServiceUnavailable {
+		t.Errorf("want status %d before the check has run, got: %d", http.StatusServiceUnavailable, got)
+	}
+
+	if err := os.WriteFile(marker, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := ready(); got != http.StatusServiceUnavailable {
+		t.Errorf("want the result to be cached until the check runs, got: %d", got)
+	}
+
+	background.check(context.Background())
+	if got := ready(); got != http.StatusOK {
+		t.Errorf("want status %d once the check has passed, got: %d", http.StatusOK, got)
+	}
+}
+
+func TestHealth_Verbose(t *testing.T) {
+	lockPresent := false
+	checks := newBuiltinChecks(func() bool { return true }, func() bool { return lockPresent }, &testLimiter{met: true}, nil)
+	handler := makeHealthHandler(checks)
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/_/health?verbose", nil))
+
+	lockPresent = true
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/_/health?verbose", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("want status %d, got: %d", http.StatusOK, rr.Code)
+	}
+
+	report := checkReport{}
+	if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+
+	// The limiter is only used for readiness.
+	if report.Status != checkOK || len(report.Checks) != 2 {
+		t.Fatalf("want ok status with 2 liveness checks, got: %+v", report)
+	}
+
+	lock := report.Checks[1]
+	if lock.Name != "lock_file" || lock.Status != checkOK || lock.CheckedAt == nil {
+		t.Errorf("want a passing lock_file check, got: %+v", lock)
+	}
+	if lock.LastError != "lock file not found" || lock.LastErrorAt == nil {
+		t.Errorf("want the last error to be kept, got: %q", lock.LastError)
+	}
+}
+
+func TestReadiness_VerboseProbesEndpoint(t *testing.T) {
+	handler := &readiness{
+		functionHandler: testUpstreamHandler("/custom/ready", http.StatusServiceUnavailable),
+		endpoint:        "/custom/ready",
+		checks:          newBuiltinChecks(func() bool { return true }, func() bool { return true }, nil, nil),
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/_/ready?verbose", nil))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("want the status of the endpoint: %d, got: %d", http.StatusServiceUnavailable, rr.Code)
+	}
+
+	report := checkReport{}
+	if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+
+	last := report.Checks[len(report.Checks)-1]
+	if report.Status != checkFail || last.Name != "upstream_http" || last.Status != checkFail {
+		t.Errorf("want a failing upstream_http check, got: %+v", report)
+	}
+}
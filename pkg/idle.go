@@ -0,0 +1,126 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// idleIgnoredUserAgent is the prefix of the User-Agent of Kubernetes
+// probes, which must not keep the watchdog running.
+const idleIgnoredUserAgent = "kube-probe"
+
+// idleHookTimeout is how long the idle_hook may run for.
+const idleHookTimeout = 30 * time.Second
+
+// idleTimer fires once no requests have been made to the function for
+// the timeout, and none are in-flight. The health and readiness
+// endpoints are not wrapped by it, so never reset it.
+type idleTimer struct {
+	timeout time.Duration
+	now     func() time.Time
+
+	mu       sync.Mutex
+	inflight int
+	last     time.Time
+
+	idle chan struct{}
+}
+
+func newIdleTimer(timeout time.Duration, now func() time.Time) *idleTimer {
+	return &idleTimer{
+		timeout: timeout,
+		now:     now,
+		last:    now(),
+		idle:    make(chan struct{}),
+	}
+}
+
+// Handler resets the timer for each request to next.
+func (t *idleTimer) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.UserAgent(), idleIgnoredUserAgent) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		t.mu.Lock()
+		t.inflight++
+		t.last = t.now()
+		t.mu.Unlock()
+
+		defer func() {
+			t.mu.Lock()
+			t.inflight--
+			t.last = t.now()
+			t.mu.Unlock()
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Idle is closed once the watchdog has been idle for the timeout.
+func (t *idleTimer) Idle() <-chan struct{} {
+	return t.idle
+}
+
+// Run waits until the watchdog has been idle for the timeout, or ctx
+// is cancelled.
+func (t *idleTimer) Run(ctx context.Context) {
+	for {
+		remaining := t.remaining()
+		if remaining <= 0 {
+			close(t.idle)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(remaining):
+		}
+	}
+}
+
+// remaining is how long until the timeout, which is the whole timeout
+// whilst a request is in-flight.
+func (t *idleTimer) remaining() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.inflight > 0 {
+		return t.timeout
+	}
+
+	return t.timeout - t.now().Sub(t.last)
+}
+
+// runIdleHook runs command, such as a script to tell a supervisor that
+// the watchdog stopped because it was idle.
+func runIdleHook(command string) {
+	parts := strings.Fields(command)
+	if len(parts) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), idleHookTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, parts[0], parts[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	log.Printf("Running idle_hook: %q\n", command)
+	if err := cmd.Run(); err != nil {
+		log.Printf("Error running idle_hook: %s\n", err)
+	}
+}
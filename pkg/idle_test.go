@@ -0,0 +1,94 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIdleTimer_Remaining(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	timer := newIdleTimer(time.Minute, clock.Now)
+
+	clock.Advance(20 * time.Second)
+	if got := timer.remaining(); got != 40*time.Second {
+		t.Errorf("want 40s remaining, got: %s", got)
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := serveAsync(timer.Handler(blockingHandler(started, release)), httptest.NewRequest(http.MethodGet, "/", nil))
+	<-started
+
+	clock.Advance(2 * time.Minute)
+	if got := timer.remaining(); got != time.Minute {
+		t.Errorf("want the whole timeout remaining whilst in-flight, got: %s", got)
+	}
+
+	close(release)
+	<-done
+
+	clock.Advance(10 * time.Second)
+	if got := timer.remaining(); got != 50*time.Second {
+		t.Errorf("want 50s remaining after the request finished, got: %s", got)
+	}
+}
+
+func TestIdleTimer_IgnoresProbes(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	timer := newIdleTimer(time.Minute, clock.Now)
+
+	clock.Advance(30 * time.Second)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("User-Agent", "kube-probe/1.29")
+	timer.Handler(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), req)
+
+	if got := timer.remaining(); got != 30*time.Second {
+		t.Errorf("want kube-probe to be ignored with 30s remaining, got: %s", got)
+	}
+}
+
+func TestRun_StopsWhenIdle(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "idle")
+
+	cfg := testConfig()
+	cfg.IdleTimeout = 100 * time.Millisecond
+	cfg.IdleHook = "touch " + marker
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := New(WithConfig(cfg), WithListener(l), WithHandler(func(w http.ResponseWriter, r *http.Request) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := w.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("want the watchdog to stop before the context was cancelled")
+	}
+	if !w.StoppedIdle() {
+		t.Error("want StoppedIdle to be true")
+	}
+	if w.State() != StateStopped {
+		t.Errorf("want state %s, got: %s", StateStopped, w.State())
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("want idle_hook to have run: %s", err)
+	}
+}
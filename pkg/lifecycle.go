@@ -0,0 +1,220 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// State is a stage in the lifecycle of the watchdog.
+type State int
+
+const (
+	// StateStarting is set whilst the function handler and listeners are
+	// being set up, before the lock file has been written.
+	StateStarting State = iota
+
+	// StateReady is set once the watchdog is accepting connections.
+	StateReady
+
+	// StateDraining is set after a SIGTERM or the context being cancelled,
+	// whilst in-flight requests are allowed to complete.
+	StateDraining
+
+	// StateStopped is set once the HTTP server has been shut down.
+	StateStopped
+
+	// StateFailed is set when the watchdog could not start, or stopped
+	// serving unexpectedly. The error is available from Watchdog.StateError.
+	StateFailed
+)
+
+var states = []State{StateStarting, StateReady, StateDraining, StateStopped, StateFailed}
+
+func (s State) String() string {
+	switch s {
+	case StateStarting:
+		return "starting"
+	case StateReady:
+		return "ready"
+	case StateDraining:
+		return "draining"
+	case StateStopped:
+		return "stopped"
+	case StateFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText encodes the state as its name, i.e. "ready".
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// StateHook is called after the watchdog moves from one state to another,
+// err is only set when moving to StateFailed. Hooks are called in order,
+// and must not block.
+type StateHook func(from, to State, err error)
+
+// validTransitions lists the states which can be reached from each state.
+var validTransitions = map[State][]State{
+	StateStarting: {StateReady, StateDraining, StateFailed},
+	StateReady:    {StateDraining, StateFailed},
+	StateDraining: {StateStopped, StateFailed},
+	StateStopped:  {StateStarting},
+	StateFailed:   {StateStarting},
+}
+
+type lifecycle struct {
+	mu    sync.RWMutex
+	state State
+	since time.Time
+	err   error
+	hooks []StateHook
+}
+
+func newLifecycle() *lifecycle {
+	return &lifecycle{
+		state: StateStarting,
+		since: time.Now(),
+	}
+}
+
+// Current returns the current state.
+func (l *lifecycle) Current() State {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.state
+}
+
+// Err returns the error which caused StateFailed, or nil.
+func (l *lifecycle) Err() error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.err
+}
+
+// AddHook registers hook to be called on each transition.
+func (l *lifecycle) AddHook(hook StateHook) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.hooks = append(l.hooks, hook)
+}
+
+// Transition moves to the state to, an error is returned if the
+// move is not valid from the current state. Moving to the current
+// state has no effect.
+func (l *lifecycle) Transition(to State) error {
+	return l.transition(to, nil)
+}
+
+// Fail moves to StateFailed, recording err as the cause.
+func (l *lifecycle) Fail(err error) error {
+	return l.transition(StateFailed, err)
+}
+
+func (l *lifecycle) transition(to State, err error) error {
+	l.mu.Lock()
+
+	from := l.state
+	if from == to {
+		l.mu.Unlock()
+		return nil
+	}
+
+	if !canTransition(from, to) {
+		l.mu.Unlock()
+		return fmt.Errorf("invalid state transition from %s to %s", from, to)
+	}
+
+	l.state = to
+	l.since = time.Now()
+	l.err = err
+
+	hooks := make([]StateHook, len(l.hooks))
+	copy(hooks, l.hooks)
+	l.mu.Unlock()
+
+	if err != nil {
+		log.Printf("State: %s -> %s, error: %s\n", from, to, err)
+	} else {
+		log.Printf("State: %s -> %s\n", from, to)
+	}
+
+	for _, hook := range hooks {
+		hook(from, to, err)
+	}
+
+	return nil
+}
+
+func canTransition(from, to State) bool {
+	for _, s := range validTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ObserveGauge sets gauge to 1 for the current state and 0 for every
+// other state, and keeps it up to date on each transition.
+func (l *lifecycle) ObserveGauge(gauge *prometheus.GaugeVec) {
+	set := func(current State) {
+		for _, s := range states {
+			value := 0.0
+			if s == current {
+				value = 1
+			}
+			gauge.WithLabelValues(s.String()).Set(value)
+		}
+	}
+
+	set(l.Current())
+	l.AddHook(func(_, to State, _ error) {
+		set(to)
+	})
+}
+
+type stateResponse struct {
+	State State     `json:"state"`
+	Since time.Time `json:"since"`
+	Error string    `json:"error,omitempty"`
+}
+
+// ServeHTTP reports the current state as JSON, along with the time it
+// was entered and any error.
+func (l *lifecycle) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		l.mu.RLock()
+		res := stateResponse{
+			State: l.state,
+			Since: l.since,
+		}
+		if l.err != nil {
+			res.Error = l.err.Error()
+		}
+		l.mu.RUnlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(res)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLifecycle_Transitions(t *testing.T) {
+	cases := []struct {
+		name    string
+		path    []State
+		wantErr bool
+	}{
+		{
+			name: "start, serve and stop",
+			path: []State{StateReady, StateDraining, StateStopped},
+		},
+		{
+			name: "restart after stopping",
+			path: []State{StateReady, StateDraining, StateStopped, StateStarting},
+		},
+		{
+			name:    "cannot become ready whilst draining",
+			path:    []State{StateReady, StateDraining, StateReady},
+			wantErr: true,
+		},
+		{
+			name:    "cannot stop without draining",
+			path:    []State{StateReady, StateStopped},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := newLifecycle()
+
+			var err error
+			for _, s := range tc.path {
+				if err = l.Transition(s); err != nil {
+					break
+				}
+			}
+
+			if tc.wantErr && err == nil {
+				t.Fatalf("want error for path %v", tc.path)
+			}
+			if !tc.wantErr && err != nil {
+				t.Fatalf("want no error, got: %s", err)
+			}
+		})
+	}
+}
+
+func TestLifecycle_HooksAndGauge(t *testing.T) {
+	l := newLifecycle()
+
+	var got []State
+	l.AddHook(func(from, to State, err error) {
+		got = append(got, to)
+	})
+
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "state"}, []string{"state"})
+	l.ObserveGauge(gauge)
+
+	if v := testutil.ToFloat64(gauge.WithLabelValues("starting")); v != 1 {
+		t.Errorf("want starting gauge to be 1, got: %f", v)
+	}
+
+	l.Transition(StateReady)
+	l.Fail(errors.New("listener closed"))
+
+	if len(got) != 2 || got[0] != StateReady || got[1] != StateFailed {
+		t.Errorf("want hooks for ready and failed, got: %v", got)
+	}
+
+	if v := testutil.ToFloat64(gauge.WithLabelValues("failed")); v != 1 {
+		t.Errorf("want failed gauge to be 1, got: %f", v)
+	}
+	if v := testutil.ToFloat64(gauge.WithLabelValues("ready")); v != 0 {
+		t.Errorf("want ready gauge to be 0, got: %f", v)
+	}
+}
+
+func TestLifecycle_ServeHTTP(t *testing.T) {
+	l := newLifecycle()
+	l.Fail(errors.New("cannot write lock file"))
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/_/state", nil)
+	l.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status %d, got: %d", http.StatusOK, rr.Code)
+	}
+
+	var res struct {
+		State string `json:"state"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+
+	if res.State != "failed" {
+		t.Errorf("want state failed, got: %s", res.State)
+	}
+	if res.Error != "cannot write lock file" {
+		t.Errorf("want error to be reported, got: %q", res.Error)
+	}
+}
+
+func TestRun_FailedStateOnListenError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	cfg := testConfig()
+	cfg.TCPPort = l.Addr().(*net.TCPAddr).Port
+
+	var hookErr error
+	w, err := New(WithConfig(cfg),
+		WithHandler(func(w http.ResponseWriter, r *http.Request) {}),
+		WithStateHook(func(from, to State, err error) {
+			hookErr = err
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runErr := w.Run(context.Background())
+	if runErr == nil {
+		t.Fatal("want error when the port is already in use")
+	}
+
+	if w.State() != StateFailed {
+		t.Errorf("want state %s, got: %s", StateFailed, w.State())
+	}
+	if hookErr != runErr || w.StateError() != runErr {
+		t.Errorf("want the error from Run to be passed to hooks and StateError")
+	}
+}
+
+func TestRun_StoppedAfterContextCancelled(t *testing.T) {
+	w, _, stop := startTestWatchdog(t, WithHandler(func(w http.ResponseWriter, r *http.Request) {}))
+
+	if w.State() != StateReady {
+		t.Errorf("want state %s, got: %s", StateReady, w.State())
+	}
+
+	if err := stop(); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+
+	if w.State() != StateStopped {
+		t.Errorf("want state %s, got: %s", StateStopped, w.State())
+	}
+}
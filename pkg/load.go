@@ -0,0 +1,26 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/openfaas/of-watchdog/metrics"
+)
+
+// makeLoadHandler reports the load as JSON, so that an autoscaler does
+// not need to scrape and aggregate the whole of /metrics.
+func makeLoadHandler(httpMetrics *metrics.Http) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(httpMetrics.Load())
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
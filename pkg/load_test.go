@@ -0,0 +1,40 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openfaas/of-watchdog/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestLoadHandler(t *testing.T) {
+	httpMetrics := metrics.NewHttp(prometheus.NewRegistry(), metrics.HttpOptions{})
+	httpMetrics.InFlightLimit.Set(5)
+
+	rr := httptest.NewRecorder()
+	makeLoadHandler(&httpMetrics)(rr, httptest.NewRequest(http.MethodGet, "/_/load", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status %d, got: %d", http.StatusOK, rr.Code)
+	}
+
+	load := metrics.Load{}
+	if err := json.NewDecoder(rr.Body).Decode(&load); err != nil {
+		t.Fatal(err)
+	}
+	if load.Limit != 5 {
+		t.Errorf("want limit of 5, got: %f", load.Limit)
+	}
+
+	rr = httptest.NewRecorder()
+	makeLoadHandler(&httpMetrics)(rr, httptest.NewRequest(http.MethodPost, "/_/load", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("want status %d, got: %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"io"
+	"log"
+
+	"github.com/openfaas/of-watchdog/config"
+	"github.com/openfaas/of-watchdog/executor"
+	"github.com/openfaas/of-watchdog/logsink"
+	"github.com/openfaas/of-watchdog/metrics"
+)
+
+// functionRuntime is shared by the runners for all invocations.
+type functionRuntime struct {
+	logs    functionLogging
+	metrics *metrics.Function
+
+	// env is added to the function's environment.
+	env []string
+
+	// process is set by the runner for http mode, which keeps one
+	// process running.
+	process *functionProcess
+}
+
+// functionLogging is shared by the runners for all invocations.
+type functionLogging struct {
+	metrics *metrics.Logs
+	limiter *executor.LogLimiter
+	sink    io.Writer
+}
+
+// newLogSink creates the syslog and file sinks which are configured,
+// the sink is nil when there are none. The returned func closes them.
+func newLogSink(cfg config.WatchdogConfig) (io.Writer, func(), error) {
+	var writers []io.Writer
+	var closers []io.Closer
+
+	closeAll := func() {
+		for _, c := range closers {
+			if err := c.Close(); err != nil {
+				log.Printf("Error closing log sink: %s", err)
+			}
+		}
+	}
+
+	if len(cfg.LogSyslogAddress) > 0 {
+		tag := cfg.LogSyslogTag
+		if len(tag) == 0 {
+			tag = "fwatchdog"
+			if name, err := getFnName(); err == nil {
+				tag = name
+			}
+		}
+
+		s, err := logsink.NewSyslog(cfg.LogSyslogAddress, tag)
+		if err != nil {
+			return nil, func() {}, err
+		}
+		writers = append(writers, s)
+		closers = append(closers, s)
+	}
+
+	if len(cfg.LogFile) > 0 {
+		f, err := logsink.NewFile(cfg.LogFile, logsink.FileOptions{
+			MaxSize:    cfg.LogFileMaxSize,
+			MaxAge:     cfg.LogFileMaxAge,
+			MaxBackups: cfg.LogFileMaxBackups,
+			Compress:   cfg.LogFileCompress,
+		}, nil)
+		if err != nil {
+			closeAll()
+			return nil, func() {}, err
+		}
+		writers = append(writers, f)
+		closers = append(closers, f)
+	}
+
+	if len(writers) == 0 {
+		return nil, closeAll, nil
+	}
+
+	return logsink.Tee(writers...), closeAll, nil
+}
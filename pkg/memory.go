@@ -0,0 +1,149 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/openfaas/of-watchdog/metrics"
+)
+
+// memoryGuard reads the memory usage and limit of the cgroup (v2) and
+// rejects new requests whilst usage is above the high watermark, until
+// it falls below the low watermark.
+type memoryGuard struct {
+	cgroupPath string
+	high       float64
+	low        float64
+	interval   time.Duration
+
+	// metrics counts the requests rejected, when set.
+	metrics *metrics.Http
+
+	pressure int32
+}
+
+func newMemoryGuard(cgroupPath string, high, low float64, interval time.Duration) *memoryGuard {
+	return &memoryGuard{
+		cgroupPath: cgroupPath,
+		high:       high,
+		low:        low,
+		interval:   interval,
+	}
+}
+
+// UnderPressure returns true whilst new requests are being rejected.
+func (m *memoryGuard) UnderPressure() bool {
+	if m == nil {
+		return false
+	}
+
+	return atomic.LoadInt32(&m.pressure) == 1
+}
+
+// Run checks the memory usage on each interval until ctx is cancelled.
+func (m *memoryGuard) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.check(); err != nil {
+			log.Printf("Unable to read cgroup memory usage: %s\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *memoryGuard) check() error {
+	usage, limit, err := m.read()
+	if err != nil {
+		return err
+	}
+
+	// No limit is set for the cgroup.
+	if limit == 0 {
+		atomic.StoreInt32(&m.pressure, 0)
+		return nil
+	}
+
+	ratio := float64(usage) / float64(limit)
+
+	switch {
+	case ratio >= m.high && !m.UnderPressure():
+		log.Printf("Memory usage %.0f%% is above the high watermark of %.0f%%, rejecting new requests\n", ratio*100, m.high*100)
+		atomic.StoreInt32(&m.pressure, 1)
+	case ratio < m.low && m.UnderPressure():
+		log.Printf("Memory usage %.0f%% is below the low watermark of %.0f%%, accepting new requests\n", ratio*100, m.low*100)
+		atomic.StoreInt32(&m.pressure, 0)
+	}
+
+	return nil
+}
+
+// read returns the current usage and the limit in bytes, the limit is
+// 0 when memory.max is "max".
+func (m *memoryGuard) read() (uint64, uint64, error) {
+	usage, err := readCgroupValue(filepath.Join(m.cgroupPath, "memory.current"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	limit, err := readCgroupValue(filepath.Join(m.cgroupPath, "memory.max"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return usage, limit, nil
+}
+
+func readCgroupValue(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0, nil
+	}
+
+	parsed, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value in %s: %w", path, err)
+	}
+
+	return parsed, nil
+}
+
+// Handler rejects requests with a 503 whilst under memory pressure.
+func (m *memoryGuard) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.UnderPressure() {
+			countRejection(m.metrics, "memory")
+
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Add("X-OpenFaaS-Internal", "of-watchdog")
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			fmt.Fprintf(w, "Memory usage is above the high watermark of %.0f%%\n", m.high*100)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
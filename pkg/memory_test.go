@@ -0,0 +1,109 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCgroupFixture(t *testing.T, dir, current, max string) {
+	t.Helper()
+
+	if err := os.WriteFile(filepath.Join(dir, "memory.current"), []byte(current+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "memory.max"), []byte(max+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryGuard_Hysteresis(t *testing.T) {
+	dir := t.TempDir()
+	m := newMemoryGuard(dir, 0.9, 0.7, time.Second)
+	handler := m.Handler(http.NotFoundHandler())
+
+	steps := []struct {
+		current  string
+		pressure bool
+	}{
+		{current: "500", pressure: false},
+		{current: "950", pressure: true},
+		// between the watermarks, so stays under pressure
+		{current: "800", pressure: true},
+		{current: "600", pressure: false},
+		// between the watermarks, so stays accepting
+		{current: "800", pressure: false},
+	}
+
+	for _, step := range steps {
+		writeCgroupFixture(t, dir, step.current, "1000")
+		if err := m.check(); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := m.UnderPressure(); got != step.pressure {
+			t.Fatalf("at %s/1000 want pressure: %t, got: %t", step.current, step.pressure, got)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		want := http.StatusNotFound
+		if step.pressure {
+			want = http.StatusServiceUnavailable
+		}
+		if rr.Code != want {
+			t.Fatalf("at %s/1000 want status %d, got: %d", step.current, want, rr.Code)
+		}
+	}
+}
+
+func TestMemoryGuard_NoLimit(t *testing.T) {
+	dir := t.TempDir()
+	writeCgroupFixture(t, dir, "123456789", "max")
+
+	m := newMemoryGuard(dir, 0.5, 0.4, time.Second)
+	if err := m.check(); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.UnderPressure() {
+		t.Error("want no pressure when memory.max is unlimited")
+	}
+}
+
+func TestMemoryGuard_MissingFiles(t *testing.T) {
+	m := newMemoryGuard(t.TempDir(), 0.5, 0.4, time.Second)
+
+	if err := m.check(); err == nil {
+		t.Error("want error when the cgroup files do not exist")
+	}
+}
+
+func TestReadinessHandler_MemoryPressure(t *testing.T) {
+	dir := t.TempDir()
+	writeCgroupFixture(t, dir, "990", "1000")
+
+	m := newMemoryGuard(dir, 0.9, 0.8, time.Second)
+	if err := m.check(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := &readiness{
+		functionHandler: http.NotFoundHandler(),
+		checks:          newBuiltinChecks(func() bool { return true }, func() bool { return true }, nil, m),
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/_/ready", nil))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("want status %d, got: %d", http.StatusServiceUnavailable, rr.Code)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"time"
+
+	"github.com/openfaas/of-watchdog/metrics"
+	"github.com/openfaas/of-watchdog/statsd"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// watchdogMetrics are registered on the first Run of a Watchdog and kept
+// for any later Run, so that the collectors and state hooks are not added
+// again.
+type watchdogMetrics struct {
+	registry *prometheus.Registry
+
+	// registerer adds the function's name and namespace to the
+	// watchdog's own metrics, when configured.
+	registerer prometheus.Registerer
+
+	logs     *metrics.Logs
+	function *metrics.Function
+	http     metrics.Http
+
+	// statsd is nil unless statsd_address is set, it listens on each Run.
+	statsd *statsd.Server
+}
+
+// registerMetrics returns the metrics of the watchdog, registering them
+// the first time it is called.
+func (w *Watchdog) registerMetrics() (*watchdogMetrics, error) {
+	w.metricsOnce.Do(func() {
+		w.metrics, w.metricsErr = w.newMetrics()
+	})
+
+	return w.metrics, w.metricsErr
+}
+
+func (w *Watchdog) newMetrics() (*watchdogMetrics, error) {
+	m := &watchdogMetrics{registry: w.registry}
+	if m.registry == nil {
+		m.registry = metrics.NewRegistry()
+	}
+
+	m.registerer = m.registry
+	if w.config.MetricsFunctionLabels {
+		m.registerer = prometheus.WrapRegistererWith(functionLabels(), m.registry)
+	}
+
+	var routes *metrics.Routes
+	if len(w.config.MetricsRoutes) > 0 {
+		routes = metrics.NewRoutes(w.config.MetricsRoutes)
+	}
+
+	m.logs = metrics.NewLogs(m.registerer)
+	m.function = metrics.NewFunction(m.registerer)
+	m.http = metrics.NewHttp(m.registerer, metrics.HttpOptions{
+		Routes:           routes,
+		Buckets:          w.config.MetricsBuckets,
+		NativeHistograms: w.config.MetricsNativeHistograms,
+		Exemplars:        w.config.MetricsExemplars,
+	})
+
+	if len(w.config.StatsDAddress) > 0 {
+		server, err := newStatsDServer(w.config, m.registerer)
+		if err != nil {
+			return nil, err
+		}
+		m.statsd = server
+	}
+
+	w.state.ObserveGauge(metrics.NewStateGauge(m.registerer))
+
+	timeToReady := metrics.NewTimeToReadyGauge(m.registerer)
+	w.state.AddHook(func(_, to State, _ error) {
+		if to == StateReady {
+			timeToReady.Set(time.Since(w.started).Seconds())
+		}
+	})
+
+	return m, nil
+}
@@ -0,0 +1,91 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/openfaas/of-watchdog/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Option configures a Watchdog created with New.
+type Option func(*Watchdog)
+
+// Middleware wraps the function handler, see WithMiddleware.
+type Middleware func(http.Handler) http.Handler
+
+type route struct {
+	pattern string
+	handler http.Handler
+}
+
+// WithConfig sets the configuration for the watchdog, usually obtained
+// from config.New.
+func WithConfig(cfg config.WatchdogConfig) Option {
+	return func(w *Watchdog) {
+		w.config = cfg
+	}
+}
+
+// WithHandler runs the watchdog in inproc mode, invoking handler
+// for each request to the function.
+func WithHandler(handler http.HandlerFunc) Option {
+	return func(w *Watchdog) {
+		w.config.OperationalMode = config.ModeInproc
+		w.config.SetHandler(handler)
+	}
+}
+
+// WithListener serves the function on l instead of binding
+// the TCP port from the configuration.
+func WithListener(l net.Listener) Option {
+	return func(w *Watchdog) {
+		w.listener = l
+	}
+}
+
+// WithRoute registers an additional handler for pattern, using the
+// syntax of http.ServeMux. Routes are not wrapped by any of the function
+// middleware, so are not authenticated, limited or instrumented.
+func WithRoute(pattern string, handler http.Handler) Option {
+	return func(w *Watchdog) {
+		w.routes = append(w.routes, route{pattern: pattern, handler: handler})
+	}
+}
+
+// WithMiddleware wraps the function handler with mw. Middleware runs after
+// the request has been counted in metrics, and before authentication and
+// concurrency limiting. The first middleware given is the outermost.
+func WithMiddleware(mw ...Middleware) Option {
+	return func(w *Watchdog) {
+		w.middleware = append(w.middleware, mw...)
+	}
+}
+
+// WithRegistry records the watchdog's metrics in reg instead of a
+// registry private to the watchdog.
+func WithRegistry(reg *prometheus.Registry) Option {
+	return func(w *Watchdog) {
+		w.registry = reg
+	}
+}
+
+// WithStateHook registers hook to be called each time the watchdog
+// moves to a new lifecycle state.
+func WithStateHook(hook StateHook) Option {
+	return func(w *Watchdog) {
+		w.state.AddHook(hook)
+	}
+}
+
+// WithTracerProvider records spans for each invocation with tp, in place
+// of the OTLP exporter configured by trace_otlp_endpoint.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(w *Watchdog) {
+		w.tracerProvider = tp
+	}
+}
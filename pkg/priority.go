@@ -0,0 +1,149 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/openfaas/faas-provider/httputil"
+	"github.com/openfaas/of-watchdog/config"
+	"github.com/openfaas/of-watchdog/metrics"
+)
+
+// priorityLimiter limits the requests in-flight like the concurrency
+// limiter, but reserves a share of the slots for high priority requests.
+// Low priority requests are shed once only the reserved slots are left.
+type priorityLimiter struct {
+	next        http.Handler
+	maxInflight int
+	reserved    int
+	header      string
+	claim       string
+	defaultTo   string
+	metrics     *metrics.Http
+
+	mu       sync.Mutex
+	inflight int
+}
+
+func newPriorityLimiter(next http.Handler, cfg config.WatchdogConfig, httpMetrics *metrics.Http) *priorityLimiter {
+	reserved := int(math.Ceil(float64(cfg.MaxInflight) * cfg.PriorityReservedShare))
+	if reserved >= cfg.MaxInflight {
+		// Always leave at least one slot for low priority requests.
+		reserved = cfg.MaxInflight - 1
+	}
+
+	return &priorityLimiter{
+		next:        next,
+		maxInflight: cfg.MaxInflight,
+		reserved:    reserved,
+		header:      cfg.PriorityHeader,
+		claim:       cfg.PriorityClaim,
+		defaultTo:   cfg.PriorityDefault,
+		metrics:     httpMetrics,
+	}
+}
+
+// Met returns true when all of the slots are in use, including
+// those reserved for high priority requests.
+func (p *priorityLimiter) Met() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.inflight >= p.maxInflight
+}
+
+func (p *priorityLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	class := p.classify(r)
+
+	limit := p.maxInflight
+	if class == config.PriorityLow {
+		limit = p.maxInflight - p.reserved
+	}
+
+	p.mu.Lock()
+	if p.inflight >= limit {
+		p.mu.Unlock()
+
+		if p.metrics != nil {
+			p.metrics.PriorityShed.WithLabelValues(class).Inc()
+		}
+		countRejection(p.metrics, "priority")
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Add("X-OpenFaaS-Internal", "of-watchdog")
+		w.WriteHeader(http.StatusTooManyRequests)
+
+		fmt.Fprintf(w, "Concurrent request limit exceeded for %s priority. Max concurrent requests: %d\n", class, limit)
+		return
+	}
+	p.inflight++
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		p.inflight--
+		p.mu.Unlock()
+	}()
+
+	if p.metrics == nil {
+		p.next.ServeHTTP(w, r)
+		return
+	}
+
+	p.metrics.PriorityAdmitted.WithLabelValues(class).Inc()
+
+	start := time.Now()
+	ww := httputil.NewHttpWriteInterceptor(w)
+	p.next.ServeHTTP(ww, r)
+
+	p.metrics.PriorityDuration.WithLabelValues(class).Observe(time.Since(start).Seconds())
+}
+
+// classify returns the priority class from the claim in the bearer token
+// when a claim is configured, otherwise from the header. The header is
+// never used alongside a claim, since any caller could set it to jump
+// the queue.
+//
+// The token is parsed without being verified again, since a claim
+// requires jwt_auth and the JWT middleware wraps the limiter, so only
+// requests with a verified token reach it.
+func (p *priorityLimiter) classify(r *http.Request) string {
+	var value string
+	if len(p.claim) > 0 {
+		value = bearerClaim(r, p.claim)
+	} else {
+		value = r.Header.Get(p.header)
+	}
+
+	switch strings.ToLower(value) {
+	case config.PriorityHigh:
+		return config.PriorityHigh
+	case config.PriorityLow:
+		return config.PriorityLow
+	default:
+		return p.defaultTo
+	}
+}
+
+func bearerClaim(r *http.Request, claim string) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+		return ""
+	}
+
+	value, _ := claims[claim].(string)
+	return value
+}
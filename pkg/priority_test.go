@@ -0,0 +1,146 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/openfaas/of-watchdog/config"
+	"github.com/openfaas/of-watchdog/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func priorityConfig(maxInflight int, share float64) config.WatchdogConfig {
+	return config.WatchdogConfig{
+		MaxInflight:           maxInflight,
+		PriorityLanes:         true,
+		PriorityHeader:        "X-Priority",
+		PriorityReservedShare: share,
+		PriorityDefault:       config.PriorityLow,
+	}
+}
+
+func priorityRequest(class string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	if len(class) > 0 {
+		req.Header.Set("X-Priority", class)
+	}
+	return req
+}
+
+func TestPriorityLimiter_ShedsLowPriorityFirst(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+
+	httpMetrics := metrics.NewHttp(prometheus.NewRegistry(), metrics.HttpOptions{})
+
+	// 2 slots, with 1 reserved for high priority.
+	p := newPriorityLimiter(blockingHandler(started, release), priorityConfig(2, 0.5), &httpMetrics)
+
+	first := serveAsync(p, priorityRequest("low"))
+	<-started
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, priorityRequest("low"))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("want low priority request to be shed, got status: %d", rr.Code)
+	}
+
+	if p.Met() {
+		t.Error("want limit not to be met whilst a reserved slot is free")
+	}
+
+	second := serveAsync(p, priorityRequest("high"))
+	<-started
+
+	if !p.Met() {
+		t.Error("want limit to be met once the reserved slot is used")
+	}
+
+	rr = httptest.NewRecorder()
+	p.ServeHTTP(rr, priorityRequest("high"))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("want high priority request to be rejected when full, got status: %d", rr.Code)
+	}
+
+	close(release)
+	<-first
+	<-second
+
+	if v := testutil.ToFloat64(httpMetrics.PriorityShed.WithLabelValues("low")); v != 1 {
+		t.Errorf("want 1 low priority request shed, got: %f", v)
+	}
+	if v := testutil.ToFloat64(httpMetrics.PriorityAdmitted.WithLabelValues("high")); v != 1 {
+		t.Errorf("want 1 high priority request admitted, got: %f", v)
+	}
+}
+
+func TestPriorityLimiter_Classify(t *testing.T) {
+	p := newPriorityLimiter(http.NotFoundHandler(), priorityConfig(10, 0.2), nil)
+
+	cases := []struct {
+		name string
+		req  *http.Request
+		want string
+	}{
+		{name: "header", req: priorityRequest("HIGH"), want: config.PriorityHigh},
+		{name: "default when missing", req: priorityRequest(""), want: config.PriorityLow},
+		{name: "default when unknown", req: priorityRequest("urgent"), want: config.PriorityLow},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := p.classify(tc.req); got != tc.want {
+				t.Errorf("want class %s, got: %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestPriorityLimiter_ClassifyClaim(t *testing.T) {
+	cfg := priorityConfig(10, 0.2)
+	cfg.PriorityClaim = "priority"
+	p := newPriorityLimiter(http.NotFoundHandler(), cfg, nil)
+
+	withClaim := func(class, header string) *http.Request {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"priority": class}).SignedString([]byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := priorityRequest(header)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return req
+	}
+
+	cases := []struct {
+		name string
+		req  *http.Request
+		want string
+	}{
+		{name: "claim", req: withClaim("high", ""), want: config.PriorityHigh},
+		{name: "claim over header", req: withClaim("low", "high"), want: config.PriorityLow},
+		{name: "header ignored without a token", req: priorityRequest("high"), want: config.PriorityLow},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := p.classify(tc.req); got != tc.want {
+				t.Errorf("want class %s, got: %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestPriorityLimiter_LeavesOneSlotForLowPriority(t *testing.T) {
+	p := newPriorityLimiter(http.NotFoundHandler(), priorityConfig(1, 0.9), nil)
+
+	if p.reserved != 0 {
+		t.Errorf("want no reserved slots with a limit of 1, got: %d", p.reserved)
+	}
+}
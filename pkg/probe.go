@@ -0,0 +1,22 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+)
+
+// Probe requests path, such as /_/health or /_/ready, from the watchdog
+// listening on port on this host, so that an exec probe can use the same
+// logic as an HTTP probe in an image without curl. An error is returned
+// when the watchdog can't be reached, or does not return a 2xx or 3xx.
+func Probe(ctx context.Context, port int, path string) error {
+	url := fmt.Sprintf("http://127.0.0.1:%d%s", port, path)
+	if err := httpCheck(ctx, url); err != nil {
+		return fmt.Errorf("probe of %s failed: %w", url, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestProbe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_/health" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	port := server.Listener.Addr().(*net.TCPAddr).Port
+
+	if err := Probe(context.Background(), port, "/_/health"); err != nil {
+		t.Errorf("want a passing probe, got: %s", err)
+	}
+	if err := Probe(context.Background(), port, "/_/ready"); err == nil {
+		t.Error("want an error for a 503")
+	}
+
+	server.Close()
+	if err := Probe(context.Background(), port, "/_/health"); err == nil {
+		t.Error("want an error when the watchdog is not listening")
+	}
+}
+
+func TestRun_LockPath(t *testing.T) {
+	cfg := testConfig()
+	cfg.SuppressLock = false
+	cfg.LockPath = filepath.Join(t.TempDir(), "run", ".lock")
+
+	w, _, stop := startTestWatchdog(t,
+		WithConfig(cfg),
+		WithHandler(func(w http.ResponseWriter, r *http.Request) {}))
+
+	if !lockFilePresent(cfg.LockPath) || !w.LockFilePresent() {
+		t.Errorf("want the lock file at %s", cfg.LockPath)
+	}
+
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+	if lockFilePresent(cfg.LockPath) {
+		t.Error("want the lock file to be removed when draining")
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"context"
+	"log"
+	"time"
+
+	units "github.com/docker/go-units"
+	"github.com/openfaas/of-watchdog/executor"
+	"github.com/openfaas/of-watchdog/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// procRoot is where procfs is mounted.
+const procRoot = "/proc"
+
+// restartGracePeriod is how long the function process has to exit after
+// a SIGTERM, before it is killed to be restarted.
+const restartGracePeriod = 5 * time.Second
+
+// functionProcess is the long-running process of http mode, which is
+// set once it has started.
+type functionProcess struct {
+	runner *executor.HTTPFunctionRunner
+}
+
+// processWatcher restarts the function process whenever the resident
+// memory of its process tree is over maxRSS.
+type processWatcher struct {
+	usage    func() (metrics.ProcUsage, error)
+	restart  func() error
+	maxRSS   int64
+	interval time.Duration
+	restarts prometheus.Counter
+}
+
+// Run checks the memory of the process on each interval until ctx
+// is cancelled.
+func (p *processWatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.check()
+		}
+	}
+}
+
+func (p *processWatcher) check() {
+	usage, err := p.usage()
+	if err != nil {
+		log.Printf("Unable to read function process memory: %s\n", err)
+		return
+	}
+
+	if usage.RSSBytes <= p.maxRSS {
+		return
+	}
+
+	log.Printf("Restarting function process, memory: %s is over process_max_rss: %s\n",
+		units.BytesSize(float64(usage.RSSBytes)),
+		units.BytesSize(float64(p.maxRSS)))
+
+	if err := p.restart(); err != nil {
+		log.Printf("Unable to restart function process: %s\n", err)
+		return
+	}
+
+	if p.restarts != nil {
+		p.restarts.Inc()
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"testing"
+
+	"github.com/openfaas/of-watchdog/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestProcessWatcher_RestartsOverMaxRSS(t *testing.T) {
+	rss := int64(100)
+	restarts := 0
+
+	w := &processWatcher{
+		usage: func() (metrics.ProcUsage, error) {
+			return metrics.ProcUsage{RSSBytes: rss}, nil
+		},
+		restart: func() error {
+			restarts++
+			return nil
+		},
+		maxRSS: 200,
+		restarts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "restarts_total",
+		}),
+	}
+
+	w.check()
+	if restarts != 0 {
+		t.Fatalf("want no restart under max RSS, got: %d", restarts)
+	}
+
+	rss = 201
+	w.check()
+	if restarts != 1 {
+		t.Fatalf("want a restart over max RSS, got: %d", restarts)
+	}
+
+	if got := testutil.ToFloat64(w.restarts); got != 1 {
+		t.Errorf("want 1 restart counted, got: %f", got)
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/openfaas/of-watchdog/executor"
+	"github.com/openfaas/of-watchdog/metrics"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// latencyWeight is the weight given to each new sample in the moving
+// average of request latency used to calculate Retry-After.
+const latencyWeight = 0.2
+
+// queueLimiter limits the number of requests in-flight like the
+// concurrency limiter, but holds up to maxQueue requests in a FIFO
+// queue for up to maxWait, instead of rejecting them straight away.
+type queueLimiter struct {
+	next        http.Handler
+	maxInflight int
+	maxQueue    int
+	maxWait     time.Duration
+	metrics     *metrics.Http
+
+	mu       sync.Mutex
+	inflight int
+	waiting  *list.List
+	latency  time.Duration
+}
+
+type queueWaiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+func newQueueLimiter(next http.Handler, maxInflight, maxQueue int, maxWait time.Duration, httpMetrics *metrics.Http) *queueLimiter {
+	return &queueLimiter{
+		next:        next,
+		maxInflight: maxInflight,
+		maxQueue:    maxQueue,
+		maxWait:     maxWait,
+		metrics:     httpMetrics,
+		waiting:     list.New(),
+	}
+}
+
+// Met returns true when all of the in-flight slots are in use.
+func (q *queueLimiter) Met() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.inflight >= q.maxInflight
+}
+
+func (q *queueLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	q.mu.Lock()
+	if q.inflight < q.maxInflight {
+		q.inflight++
+		q.mu.Unlock()
+
+		q.serve(w, r)
+		return
+	}
+
+	if q.waiting.Len() >= q.maxQueue {
+		retryAfter := q.retryAfter()
+		q.mu.Unlock()
+
+		q.reject(w, retryAfter)
+		return
+	}
+
+	waiter := &queueWaiter{ready: make(chan struct{})}
+	elem := q.waiting.PushBack(waiter)
+	q.setDepth()
+	q.mu.Unlock()
+
+	_, span := executor.StartSpan(r.Context(), "queue")
+	outcome := q.wait(r, elem, waiter)
+	span.SetAttributes(attribute.String("queue.outcome", outcome))
+	span.End()
+
+	q.observeWait(outcome, start)
+
+	switch outcome {
+	case "admitted":
+		q.serve(w, r)
+	case "timeout":
+		q.mu.Lock()
+		retryAfter := q.retryAfter()
+		q.mu.Unlock()
+
+		q.reject(w, retryAfter)
+	case "cancelled":
+		// The client has gone away, so there's no one to write to.
+	}
+}
+
+// wait blocks until the waiter is given a slot, the maximum wait
+// time passes or the client goes away, and returns the outcome.
+func (q *queueLimiter) wait(r *http.Request, elem *list.Element, waiter *queueWaiter) string {
+	var timeout <-chan time.Time
+	if q.maxWait > 0 {
+		timer := time.NewTimer(q.maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-waiter.ready:
+		return "admitted"
+	case <-timeout:
+		if q.abandon(elem, waiter) {
+			return "admitted"
+		}
+
+		return "timeout"
+	case <-r.Context().Done():
+		if q.abandon(elem, waiter) {
+			// The slot was handed over at the same time as the client
+			// went away, so give it to the next request in the queue.
+			q.release()
+		}
+
+		return "cancelled"
+	}
+}
+
+// serve runs the request in a slot which has already been taken, then
+// hands the slot to the next request in the queue.
+func (q *queueLimiter) serve(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		q.observeLatency(time.Since(start))
+		q.release()
+	}()
+
+	q.next.ServeHTTP(w, r)
+}
+
+// release passes the slot to the first request in the queue, or frees
+// it when the queue is empty.
+func (q *queueLimiter) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	front := q.waiting.Front()
+	if front == nil {
+		q.inflight--
+		return
+	}
+
+	waiter := q.waiting.Remove(front).(*queueWaiter)
+	waiter.granted = true
+	close(waiter.ready)
+	q.setDepth()
+}
+
+// abandon removes a waiter from the queue, it returns true if the waiter
+// was granted a slot before it could be removed.
+func (q *queueLimiter) abandon(elem *list.Element, waiter *queueWaiter) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if waiter.granted {
+		return true
+	}
+
+	q.waiting.Remove(elem)
+	q.setDepth()
+	return false
+}
+
+func (q *queueLimiter) observeLatency(d time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.latency == 0 {
+		q.latency = d
+		return
+	}
+
+	q.latency = time.Duration(latencyWeight*float64(d) + (1-latencyWeight)*float64(q.latency))
+}
+
+// retryAfter estimates the whole seconds until a request made now would
+// be admitted, based upon the average latency and the requests ahead of
+// it. It must be called with the lock held.
+func (q *queueLimiter) retryAfter() int {
+	ahead := float64(q.waiting.Len() + 1)
+	wait := q.latency.Seconds() * ahead / float64(q.maxInflight)
+
+	return int(math.Max(1, math.Ceil(wait)))
+}
+
+// setDepth must be called with the lock held.
+func (q *queueLimiter) setDepth() {
+	if q.metrics != nil {
+		q.metrics.QueueDepth.Set(float64(q.waiting.Len()))
+	}
+}
+
+func (q *queueLimiter) observeWait(outcome string, start time.Time) {
+	if q.metrics != nil {
+		q.metrics.QueueWaitHistogram.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+	}
+}
+
+func (q *queueLimiter) reject(w http.ResponseWriter, retryAfter int) {
+	countRejection(q.metrics, "queue")
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.Header().Add("X-OpenFaaS-Internal", "of-watchdog")
+
+	w.WriteHeader(http.StatusTooManyRequests)
+
+	fmt.Fprintf(w, "Concurrent request limit exceeded. Max concurrent requests: %d, max queued requests: %d\n", q.maxInflight, q.maxQueue)
+}
@@ -0,0 +1,176 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openfaas/of-watchdog/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// blockingHandler blocks each request until a value is sent on release.
+func blockingHandler(started chan<- struct{}, release <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func serveAsync(h http.Handler, r *http.Request) <-chan *httptest.ResponseRecorder {
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		done <- rr
+	}()
+	return done
+}
+
+func waitForDepth(t *testing.T, q *queueLimiter, want int) {
+	t.Helper()
+
+	for i := 0; i < 100; i++ {
+		q.mu.Lock()
+		got := q.waiting.Len()
+		q.mu.Unlock()
+
+		if got == want {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("queue did not reach depth %d", want)
+}
+
+func TestQueueLimiter_AdmitsQueuedRequestWhenSlotFrees(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+
+	httpMetrics := metrics.NewHttp(prometheus.NewRegistry(), metrics.HttpOptions{})
+	q := newQueueLimiter(blockingHandler(started, release), 1, 1, time.Second, &httpMetrics)
+
+	first := serveAsync(q, httptest.NewRequest(http.MethodGet, "/", nil))
+	<-started
+
+	if !q.Met() {
+		t.Fatal("want limit to be met with one request in-flight")
+	}
+
+	second := serveAsync(q, httptest.NewRequest(http.MethodGet, "/", nil))
+	waitForDepth(t, q, 1)
+
+	if v := testutil.ToFloat64(httpMetrics.QueueDepth); v != 1 {
+		t.Errorf("want queue depth metric of 1, got: %f", v)
+	}
+
+	release <- struct{}{}
+	if rr := <-first; rr.Code != http.StatusOK {
+		t.Errorf("want first request status %d, got: %d", http.StatusOK, rr.Code)
+	}
+
+	<-started
+	release <- struct{}{}
+	if rr := <-second; rr.Code != http.StatusOK {
+		t.Errorf("want queued request status %d, got: %d", http.StatusOK, rr.Code)
+	}
+
+	if q.Met() {
+		t.Error("want limit not to be met once all requests have completed")
+	}
+}
+
+func TestQueueLimiter_RejectsWhenQueueFull(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+
+	q := newQueueLimiter(blockingHandler(started, release), 1, 1, time.Second, nil)
+
+	first := serveAsync(q, httptest.NewRequest(http.MethodGet, "/", nil))
+	<-started
+
+	second := serveAsync(q, httptest.NewRequest(http.MethodGet, "/", nil))
+	waitForDepth(t, q, 1)
+
+	rr := httptest.NewRecorder()
+	q.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("want status %d, got: %d", http.StatusTooManyRequests, rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("want Retry-After of 1, got: %q", got)
+	}
+
+	close(release)
+	<-first
+	<-second
+}
+
+func TestQueueLimiter_RejectsAfterMaxWait(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+
+	q := newQueueLimiter(blockingHandler(started, release), 1, 1, 10*time.Millisecond, nil)
+
+	first := serveAsync(q, httptest.NewRequest(http.MethodGet, "/", nil))
+	<-started
+
+	rr := httptest.NewRecorder()
+	q.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("want status %d, got: %d", http.StatusTooManyRequests, rr.Code)
+	}
+	waitForDepth(t, q, 0)
+
+	close(release)
+	<-first
+}
+
+func TestQueueLimiter_RemovesCancelledRequest(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+
+	q := newQueueLimiter(blockingHandler(started, release), 1, 1, time.Second, nil)
+
+	first := serveAsync(q, httptest.NewRequest(http.MethodGet, "/", nil))
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	second := serveAsync(q, req)
+	waitForDepth(t, q, 1)
+
+	cancel()
+	<-second
+	waitForDepth(t, q, 0)
+
+	close(release)
+	<-first
+
+	if q.Met() {
+		t.Error("want the slot to be freed after the cancelled request")
+	}
+}
+
+func TestQueueLimiter_RetryAfterUsesLatency(t *testing.T) {
+	q := newQueueLimiter(http.NotFoundHandler(), 2, 10, time.Second, nil)
+	q.latency = 3 * time.Second
+	q.waiting.PushBack(&queueWaiter{})
+	q.waiting.PushBack(&queueWaiter{})
+	q.waiting.PushBack(&queueWaiter{})
+
+	// 4 requests ahead, sharing 2 slots with 3s each.
+	if got := q.retryAfter(); got != 6 {
+		t.Errorf("want Retry-After of 6, got: %d", got)
+	}
+}
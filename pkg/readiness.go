@@ -1,14 +1,20 @@
 package pkg
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
-	"sync/atomic"
+	"time"
 
-	limiter "github.com/openfaas/faas-middleware/concurrency-limiter"
+	"github.com/openfaas/of-watchdog/config"
 )
 
+// upstreamHTTPCheck is the name of the check of the ready_path.
+const upstreamHTTPCheck = "upstream_http"
+
 type readiness struct {
 	// functionHandler is the function invoke HTTP Handler. Using this allows
 	// custom ready checks in all invoke modes. For example, in forking mode
@@ -16,52 +22,58 @@ type readiness struct {
 	// and respond accordingly, exit non-zero when not ready.
 	functionHandler http.Handler
 	endpoint        string
-	lockCheck       func() bool
-	limiter         limiter.Limiter
+	checks          *checkRegistry
+
+	// timeout is the ready_timeout, after which a probe of the
+	// endpoint fails.
+	timeout time.Duration
 }
 
-// LimitMet returns true if the concurrency limit has been reached
-// or false if no limiter has been used
-func (r *readiness) LimitMet() bool {
-	if r.limiter == nil {
-		return false
-	}
-	return r.limiter.Met()
+type readinessProbeKey struct{}
+
+// isReadinessProbe returns true for the request of a probe of the
+// ready_path, so that the fork modes stop its process once it has
+// timed out.
+func isReadinessProbe(ctx context.Context) bool {
+	probe, _ := ctx.Value(readinessProbeKey{}).(bool)
+	return probe
 }
 
 func (r *readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
-		status := http.StatusOK
-
-		switch {
-		case atomic.LoadInt32(&acceptingConnections) == 0, !r.lockCheck():
-			status = http.StatusServiceUnavailable
-		case r.LimitMet():
-			status = http.StatusTooManyRequests
-		case r.endpoint != "":
-			upstream := url.URL{
-				Scheme: req.URL.Scheme,
-				Host:   req.URL.Host,
-				Path:   r.endpoint,
+		status, results := r.checks.evaluate(req.Context(), false)
+
+		if verbose(req) {
+			if status == http.StatusOK && r.endpoint != "" {
+				check := &healthCheck{
+					name: upstreamHTTPCheck,
+					run: func(ctx context.Context) error {
+						var err error
+						status, err = r.probeStatus(req.WithContext(ctx))
+						return err
+					},
+				}
+				results = append(results, check.check(req.Context()))
 			}
 
-			readyReq, err := http.NewRequestWithContext(req.Context(), http.MethodGet, upstream.String(), nil)
+			writeCheckReport(w, status, results)
+			return
+		}
+
+		if status == http.StatusOK && r.endpoint != "" {
+			rec, err := r.probeRecorded(req)
 			if err != nil {
-				log.Printf("Error creating readiness request to: %s : %s", upstream.String(), err)
-				status = http.StatusInternalServerError
-				break
+				log.Printf("Readiness probe failed: %s", err)
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
 			}
 
-			// we need to set the raw RequestURI for the function invoker to see our URL path,
-			// otherwise it will just route to `/`, typically this shouldn't be used or set
-			readyReq.RequestURI = r.endpoint
-			readyReq.Header = req.Header.Clone()
-
-			// Instead of calling http.DefaultClient.Do(), which only works with http mode
-			// calling this handler can fork a process to run a request, such as when
-			// using bash as the function.
-			r.functionHandler.ServeHTTP(w, readyReq)
+			for key, values := range rec.Header() {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(rec.Code)
+			w.Write(rec.Body.Bytes())
 			return
 		}
 
@@ -70,3 +82,97 @@ func (r *readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
+
+// probe invokes the function with the ready_path.
+func (r *readiness) probe(w http.ResponseWriter, req *http.Request) {
+	upstream := url.URL{
+		Scheme: req.URL.Scheme,
+		Host:   req.URL.Host,
+		Path:   r.endpoint,
+	}
+
+	readyReq, err := http.NewRequestWithContext(req.Context(), http.MethodGet, upstream.String(), nil)
+	if err != nil {
+		log.Printf("Error creating readiness request to: %s : %s", upstream.String(), err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// we need to set the raw RequestURI for the function invoker to see our URL path,
+	// otherwise it will just route to `/`, typically this shouldn't be used or set
+	readyReq.RequestURI = r.endpoint
+	readyReq.Header = req.Header.Clone()
+
+	// Instead of calling http.DefaultClient.Do(), which only works with http mode
+	// calling this handler can fork a process to run a request, such as when
+	// using bash as the function.
+	r.functionHandler.ServeHTTP(w, readyReq)
+}
+
+// probeStatus invokes the function with the ready_path, and returns the
+// status code, with an error unless it is a 2xx.
+func (r *readiness) probeStatus(req *http.Request) (int, error) {
+	rec, err := r.probeRecorded(req)
+	if err != nil {
+		return http.StatusServiceUnavailable, err
+	}
+
+	if rec.Code < 200 || rec.Code > 299 {
+		return rec.Code, fmt.Errorf("%s returned status: %d", r.endpoint, rec.Code)
+	}
+
+	return rec.Code, nil
+}
+
+// probeRecorded invokes the function with the ready_path, and returns its
+// response. An error is returned when the function has not responded
+// within the timeout or before the request's context is done, at which
+// point the function is expected to stop, since it is given the same
+// context.
+func (r *readiness) probeRecorded(req *http.Request) (*httptest.ResponseRecorder, error) {
+	ctx := context.WithValue(req.Context(), readinessProbeKey{}, true)
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		r.probe(rec, req.WithContext(ctx))
+	}()
+
+	select {
+	case <-done:
+		return rec, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%s did not respond: %w", r.endpoint, ctx.Err())
+	}
+}
+
+// readyProbeCheck probes the ready_path on the ready_interval, so that
+// /_/ready can return the last result instead of invoking the function,
+// which is a process fork in the fork modes, for each request.
+func readyProbeCheck(functionHandler http.Handler, cfg config.WatchdogConfig) *healthCheck {
+	r := &readiness{functionHandler: functionHandler, endpoint: cfg.ReadyEndpoint}
+
+	return &healthCheck{
+		name:             upstreamHTTPCheck,
+		interval:         cfg.ReadyInterval,
+		timeout:          cfg.ReadyTimeout,
+		successThreshold: cfg.ReadySuccessThreshold,
+		failureThreshold: cfg.ReadyFailureThreshold,
+		run: func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.ReadyEndpoint, nil)
+			if err != nil {
+				return err
+			}
+
+			_, err = r.probeStatus(req)
+			return err
+		},
+	}
+}
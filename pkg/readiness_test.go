@@ -1,9 +1,14 @@
 package pkg
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/openfaas/of-watchdog/config"
 )
 
 func TestReadinessHandler(t *testing.T) {
@@ -11,23 +16,23 @@ func TestReadinessHandler(t *testing.T) {
 		name                 string
 		endpoint             string
 		limitMet             bool
-		acceptingConnections int32
+		acceptingConnections bool
 		readyResponseCode    int
 		expectedCode         int
 	}{
 		{
 			name:                 "return 503 when not accepting connections",
-			acceptingConnections: 0,
+			acceptingConnections: false,
 			expectedCode:         http.StatusServiceUnavailable,
 		},
 		{
 			name:                 "returns 200 when no upstream endpoint and no limiter",
-			acceptingConnections: 1,
+			acceptingConnections: true,
 			expectedCode:         http.StatusOK,
 		},
 		{
 			name:                 "returns the upstream endpoint response code when no limiter",
-			acceptingConnections: 1,
+			acceptingConnections: true,
 			endpoint:             "/custom/ready",
 			readyResponseCode:    http.StatusNoContent,
 			expectedCode:         http.StatusNoContent,
@@ -35,7 +40,7 @@ func TestReadinessHandler(t *testing.T) {
 		{
 			name:                 "return 429 when limiter is met",
 			limitMet:             true,
-			acceptingConnections: 1,
+			acceptingConnections: true,
 			expectedCode:         http.StatusTooManyRequests,
 		},
 	}
@@ -46,8 +51,11 @@ func TestReadinessHandler(t *testing.T) {
 			handler := &readiness{
 				functionHandler: upstream,
 				endpoint:        tc.endpoint,
-				lockCheck:       func() bool { return true },
-				limiter:         &testLimiter{met: tc.limitMet},
+				checks: newBuiltinChecks(
+					func() bool { return tc.acceptingConnections },
+					func() bool { return true },
+					&testLimiter{met: tc.limitMet},
+					nil),
 			}
 
 			rr := httptest.NewRecorder()
@@ -56,7 +64,6 @@ func TestReadinessHandler(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			acceptingConnections = tc.acceptingConnections
 			handler.ServeHTTP(rr, req)
 
 			if status := rr.Code; status != tc.expectedCode {
@@ -92,3 +99,112 @@ func (t *testLimiter) Met() bool {
 	}
 	return t.met
 }
+
+func TestReadyProbeCheck_Thresholds(t *testing.T) {
+	code := http.StatusOK
+	invoked := 0
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		invoked++
+		if r.URL.Path != "/custom/ready" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(code)
+	})
+
+	cfg := config.WatchdogConfig{
+		ReadyEndpoint:         "/custom/ready",
+		ReadyInterval:         time.Hour,
+		ReadyTimeout:          time.Second,
+		ReadySuccessThreshold: 2,
+		ReadyFailureThreshold: 2,
+	}
+	check := readyProbeCheck(upstream, cfg)
+
+	checks := newBuiltinChecks(func() bool { return true }, func() bool { return true }, nil, nil)
+	checks.add(check)
+	handler := &readiness{functionHandler: upstream, checks: checks}
+
+	ready := func() int {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/_/ready", nil))
+		return rr.Code
+	}
+
+	steps := []struct {
+		code int
+		want int
+	}{
+		{code: http.StatusOK, want: http.StatusServiceUnavailable},
+		{code: http.StatusOK, want: http.StatusOK},
+		{code: http.StatusInternalServerError, want: http.StatusOK},
+		{code: http.StatusOK, want: http.StatusOK},
+		{code: http.StatusInternalServerError, want: http.StatusOK},
+		{code: http.StatusInternalServerError, want: http.StatusServiceUnavailable},
+	}
+
+	for i, step := range steps {
+		code = step.code
+		check.check(context.Background())
+
+		if got := ready(); got != step.want {
+			t.Errorf("step %d: want status %d, got: %d", i, step.want, got)
+		}
+	}
+
+	if invoked != len(steps) {
+		t.Errorf("want the function invoked once per probe and not by /_/ready, got: %d calls", invoked)
+	}
+}
+
+func TestReadyProbeCheck_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+
+	check := readyProbeCheck(upstream, config.WatchdogConfig{
+		ReadyEndpoint: "/custom/ready",
+		ReadyInterval: time.Hour,
+		ReadyTimeout:  10 * time.Millisecond,
+	})
+
+	result := check.check(context.Background())
+	if result.Status != checkFail || !strings.Contains(result.LastError, "did not respond") {
+		t.Errorf("want the probe to fail after the timeout, got: %+v", result)
+	}
+}
+
+func TestReadinessHandler_TimeoutStopsProbe(t *testing.T) {
+	exited := make(chan bool, 1)
+
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		exited <- isReadinessProbe(r.Context())
+	})
+
+	handler := &readiness{
+		functionHandler: upstream,
+		endpoint:        "/custom/ready",
+		checks:          newBuiltinChecks(func() bool { return true }, func() bool { return true }, nil, nil),
+		timeout:         10 * time.Millisecond,
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/_/ready", nil))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("want status %d after the ready_timeout, got: %d", http.StatusServiceUnavailable, rr.Code)
+	}
+
+	select {
+	case probe := <-exited:
+		if !probe {
+			t.Error("want the request marked as a readiness probe")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("want the function handler to exit once the probe timed out")
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"net/http"
+
+	"github.com/openfaas/faas-provider/httputil"
+	"github.com/openfaas/of-watchdog/metrics"
+)
+
+// countRejection records a request turned away by limiter, when
+// httpMetrics is set.
+func countRejection(httpMetrics *metrics.Http, limiter string) {
+	if httpMetrics != nil {
+		httpMetrics.Rejections.WithLabelValues(limiter).Inc()
+	}
+}
+
+// countConcurrencyRejections counts the requests rejected by the
+// concurrency limiter from faas-middleware, which marks them with
+// the X-OpenFaaS-Internal header.
+func countConcurrencyRejections(next http.Handler, httpMetrics *metrics.Http) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := httputil.NewHttpWriteInterceptor(w)
+		next.ServeHTTP(ww, r)
+
+		if ww.Status() == http.StatusTooManyRequests && w.Header().Get("X-OpenFaaS-Internal") == "faas-middleware" {
+			countRejection(httpMetrics, "concurrency")
+		}
+	})
+}
@@ -0,0 +1,53 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	limiter "github.com/openfaas/faas-middleware/concurrency-limiter"
+	"github.com/openfaas/of-watchdog/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCountConcurrencyRejections(t *testing.T) {
+	httpMetrics := metrics.NewHttp(prometheus.NewRegistry(), metrics.HttpOptions{})
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	l := limiter.NewConcurrencyLimiter(slow, 1)
+	handler := countConcurrencyRejections(l.Handler(), &httpMetrics)
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+		close(done)
+	}()
+	<-started
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("want status: %d, got: %d", http.StatusTooManyRequests, rr.Code)
+	}
+
+	close(release)
+	<-done
+
+	// The 429 from the function itself is not a rejection.
+	if got := testutil.ToFloat64(httpMetrics.Rejections.WithLabelValues("concurrency")); got != 1 {
+		t.Errorf("want 1 concurrency rejection, got: %f", got)
+	}
+}
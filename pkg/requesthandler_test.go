@@ -7,27 +7,22 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"path/filepath"
 	"testing"
 )
 
 func TestHealthHandler_StatusOK_LockFilePresent(t *testing.T) {
 	rr := httptest.NewRecorder()
+	path := filepath.Join(t.TempDir(), "run", ".lock")
 
-	if lockFilePresent() {
-		path := filepath.Join(os.TempDir(), ".lock")
-		os.Remove(path)
-	}
-
-	if tmpPath, err := createLockFile(); err != nil {
+	if tmpPath, err := createLockFile(path); err != nil {
 		log.Fatalf("Error writing to %s - %s\n", tmpPath, err)
 	}
 	req, err := http.NewRequest(http.MethodGet, "/_/health", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	handler := makeHealthHandler(lockFilePresent)
+	handler := makeHealthHandler(newBuiltinChecks(func() bool { return true }, lockPresent(path), nil, nil))
 	handler(rr, req)
 
 	required := http.StatusOK
@@ -38,18 +33,13 @@ func TestHealthHandler_StatusOK_LockFilePresent(t *testing.T) {
 
 func TestHealthHandler_StatusInternalServerError_LockFileNotPresent(t *testing.T) {
 	rr := httptest.NewRecorder()
-
-	if lockFilePresent() == true {
-		if err := removeLockFile(); err != nil {
-			t.Fatal(err)
-		}
-	}
+	path := filepath.Join(t.TempDir(), ".lock")
 
 	req, err := http.NewRequest(http.MethodGet, "/_/health", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	handler := makeHealthHandler(lockFilePresent)
+	handler := makeHealthHandler(newBuiltinChecks(func() bool { return true }, lockPresent(path), nil, nil))
 	handler(rr, req)
 
 	required := http.StatusServiceUnavailable
@@ -69,7 +59,7 @@ func TestHealthHandler_StatusMethodNotAllowed_ForWriteableVerbs(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		handler := makeHealthHandler(lockFilePresent)
+		handler := makeHealthHandler(newBuiltinChecks(func() bool { return true }, lockPresent(filepath.Join(t.TempDir(), ".lock")), nil, nil))
 		handler(rr, req)
 
 		required := http.StatusMethodNotAllowed
@@ -79,8 +69,8 @@ func TestHealthHandler_StatusMethodNotAllowed_ForWriteableVerbs(t *testing.T) {
 	}
 }
 
-func removeLockFile() error {
-	path := filepath.Join(os.TempDir(), ".lock")
-	removeErr := os.Remove(path)
-	return removeErr
+func lockPresent(path string) func() bool {
+	return func() bool {
+		return lockFilePresent(path)
+	}
 }
@@ -0,0 +1,137 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/openfaas/of-watchdog/config"
+	"github.com/openfaas/of-watchdog/metrics"
+	"github.com/openfaas/of-watchdog/ratelimit"
+)
+
+// limitRuleHeader names the rule which rejected a request.
+const limitRuleHeader = "X-Limit-Rule"
+
+// ruleLimiter applies the first matching rule's concurrency and rate
+// limits to a request. Requests which match no rule are passed through.
+type ruleLimiter struct {
+	next    http.Handler
+	rules   []*limitRule
+	metrics *metrics.Http
+}
+
+type limitRule struct {
+	config.LimitRule
+
+	inflight int64
+	bucket   *ratelimit.Bucket
+}
+
+func newRuleLimiter(next http.Handler, rules []config.LimitRule, httpMetrics *metrics.Http, now func() time.Time) *ruleLimiter {
+	l := &ruleLimiter{
+		next:    next,
+		metrics: httpMetrics,
+	}
+
+	for _, rule := range rules {
+		lr := &limitRule{LimitRule: rule}
+		if rule.Rate > 0 {
+			lr.bucket = ratelimit.NewBucket(rule.Rate, rule.Burst, now)
+		}
+		l.rules = append(l.rules, lr)
+	}
+
+	return l
+}
+
+func (l *ruleLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rule := l.match(r)
+	if rule == nil {
+		l.next.ServeHTTP(w, r)
+		return
+	}
+
+	// Concurrency is checked first, so that a request which is rejected
+	// for it does not use up the rate.
+	if rule.MaxInflight > 0 {
+		if atomic.AddInt64(&rule.inflight, 1) > int64(rule.MaxInflight) {
+			atomic.AddInt64(&rule.inflight, -1)
+			l.reject(w, rule, "concurrency", fmt.Sprintf("Concurrent request limit exceeded. Max concurrent requests: %d", rule.MaxInflight), 0)
+			return
+		}
+		defer atomic.AddInt64(&rule.inflight, -1)
+	}
+
+	if rule.bucket != nil {
+		if wait, ok := rule.bucket.Take(); !ok {
+			l.reject(w, rule, "rate", fmt.Sprintf("Rate limit exceeded. Max requests per second: %g", rule.Rate), wait)
+			return
+		}
+	}
+
+	l.next.ServeHTTP(w, r)
+}
+
+func (l *ruleLimiter) match(r *http.Request) *limitRule {
+	for _, rule := range l.rules {
+		if rule.matches(r) {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+func (l *ruleLimiter) reject(w http.ResponseWriter, rule *limitRule, reason, message string, wait time.Duration) {
+	if l.metrics != nil {
+		l.metrics.LimitRejections.WithLabelValues(rule.Name, reason).Inc()
+	}
+	countRejection(l.metrics, "rule")
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set(limitRuleHeader, rule.Name)
+	w.Header().Add("X-OpenFaaS-Internal", "of-watchdog")
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	}
+
+	w.WriteHeader(http.StatusTooManyRequests)
+
+	fmt.Fprintf(w, "%s, rule: %s\n", message, rule.Name)
+}
+
+func (rule *limitRule) matches(r *http.Request) bool {
+	if !strings.HasPrefix(r.URL.Path, rule.PathPrefix) {
+		return false
+	}
+
+	if len(rule.Methods) > 0 {
+		found := false
+		for _, method := range rule.Methods {
+			if strings.EqualFold(method, r.Method) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	for name, value := range rule.Headers {
+		if r.Header.Get(name) != value {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,164 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openfaas/of-watchdog/config"
+	"github.com/openfaas/of-watchdog/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLimitRule_Matches(t *testing.T) {
+	rule := &limitRule{LimitRule: config.LimitRule{
+		Name:       "expensive",
+		PathPrefix: "/render",
+		Methods:    []string{"post"},
+		Headers:    map[string]string{"X-Tier": "free"},
+	}}
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		tier   string
+		want   bool
+	}{
+		{name: "all fields match", method: http.MethodPost, path: "/render/pdf", tier: "free", want: true},
+		{name: "wrong method", method: http.MethodGet, path: "/render/pdf", tier: "free"},
+		{name: "wrong path", method: http.MethodPost, path: "/healthz", tier: "free"},
+		{name: "wrong header", method: http.MethodPost, path: "/render", tier: "paid"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			req.Header.Set("X-Tier", tc.tier)
+
+			if got := rule.matches(req); got != tc.want {
+				t.Errorf("want match: %t, got: %t", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRuleLimiter_RateLimit(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	httpMetrics := metrics.NewHttp(prometheus.NewRegistry(), metrics.HttpOptions{})
+
+	rules := []config.LimitRule{
+		{Name: "cheap-get", Methods: []string{http.MethodGet}, Rate: 1, Burst: 2},
+	}
+	l := newRuleLimiter(http.NotFoundHandler(), rules, &httpMetrics, clock.Now)
+
+	codes := []int{}
+	for i := 0; i < 3; i++ {
+		rr := httptest.NewRecorder()
+		l.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		codes = append(codes, rr.Code)
+
+		if rr.Code == http.StatusTooManyRequests {
+			if got := rr.Header().Get(limitRuleHeader); got != "cheap-get" {
+				t.Errorf("want %s header of cheap-get, got: %q", limitRuleHeader, got)
+			}
+			if got := rr.Header().Get("Retry-After"); got != "1" {
+				t.Errorf("want Retry-After of 1, got: %q", got)
+			}
+		}
+	}
+
+	want := []int{http.StatusNotFound, http.StatusNotFound, http.StatusTooManyRequests}
+	for i := range want {
+		if codes[i] != want[i] {
+			t.Fatalf("want codes %v, got: %v", want, codes)
+		}
+	}
+
+	clock.Advance(time.Second)
+	rr := httptest.NewRecorder()
+	l.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("want a token after one second, got status: %d", rr.Code)
+	}
+
+	// POST does not match the rule, so is never limited.
+	for i := 0; i < 5; i++ {
+		rr := httptest.NewRecorder()
+		l.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+		if rr.Code != http.StatusNotFound {
+			t.Fatalf("want unmatched request to pass through, got status: %d", rr.Code)
+		}
+	}
+
+	if v := testutil.ToFloat64(httpMetrics.LimitRejections.WithLabelValues("cheap-get", "rate")); v != 1 {
+		t.Errorf("want 1 rate rejection, got: %f", v)
+	}
+	if v := testutil.ToFloat64(httpMetrics.Rejections.WithLabelValues("rule")); v != 1 {
+		t.Errorf("want 1 rejection by a rule, got: %f", v)
+	}
+}
+
+func TestRuleLimiter_ConcurrencyLimit(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+
+	httpMetrics := metrics.NewHttp(prometheus.NewRegistry(), metrics.HttpOptions{})
+	rules := []config.LimitRule{
+		{Name: "render", PathPrefix: "/render", MaxInflight: 1},
+	}
+	l := newRuleLimiter(blockingHandler(started, release), rules, &httpMetrics, nil)
+
+	done := serveAsync(l, httptest.NewRequest(http.MethodPost, "/render", nil))
+	<-started
+
+	rr := httptest.NewRecorder()
+	l.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/render", nil))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("want status %d, got: %d", http.StatusTooManyRequests, rr.Code)
+	}
+	if got := rr.Header().Get(limitRuleHeader); got != "render" {
+		t.Errorf("want %s header of render, got: %q", limitRuleHeader, got)
+	}
+
+	close(release)
+	<-done
+
+	if v := testutil.ToFloat64(httpMetrics.LimitRejections.WithLabelValues("render", "concurrency")); v != 1 {
+		t.Errorf("want 1 concurrency rejection, got: %f", v)
+	}
+}
+
+func TestRuleLimiter_ConcurrencyRejectionKeepsRate(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	rules := []config.LimitRule{
+		{Name: "render", PathPrefix: "/render", MaxInflight: 1, Rate: 1, Burst: 2},
+	}
+	l := newRuleLimiter(blockingHandler(started, release), rules, nil, clock.Now)
+
+	done := serveAsync(l, httptest.NewRequest(http.MethodPost, "/render", nil))
+	<-started
+
+	rr := httptest.NewRecorder()
+	l.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/render", nil))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("want status %d, got: %d", http.StatusTooManyRequests, rr.Code)
+	}
+
+	close(release)
+	<-done
+
+	rr = httptest.NewRecorder()
+	l.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/render", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("want the rate left for a request after a concurrency rejection, got status: %d", rr.Code)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"net"
+
+	"github.com/openfaas/of-watchdog/config"
+	"github.com/openfaas/of-watchdog/metrics"
+	"github.com/openfaas/of-watchdog/statsd"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// newStatsDServer creates a server for custom metrics from the function
+// and registers it with reg, to be served on /metrics. The server must
+// Listen before it is Run.
+func newStatsDServer(cfg config.WatchdogConfig, reg prometheus.Registerer) (*statsd.Server, error) {
+	opts := statsd.Options{
+		Prefix:    cfg.StatsDPrefix,
+		MaxSeries: cfg.StatsDMaxSeries,
+		Buckets:   cfg.MetricsBuckets,
+	}
+	if cfg.MetricsFunctionLabels {
+		for name := range functionLabels() {
+			opts.ReservedLabels = append(opts.ReservedLabels, name)
+		}
+	}
+
+	server := statsd.NewServer(opts, metrics.NewStatsD(reg))
+	if err := reg.Register(server); err != nil {
+		return nil, err
+	}
+
+	return server, nil
+}
+
+// statsDEnvironment tells StatsD and DogStatsD clients in the function
+// where to send metrics.
+func statsDEnvironment(addr net.Addr) []string {
+	host, port, _ := net.SplitHostPort(addr.String())
+
+	return []string{
+		"STATSD_ADDR=" + addr.String(),
+		"DD_AGENT_HOST=" + host,
+		"DD_DOGSTATSD_PORT=" + port,
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"net"
+	"testing"
+
+	"github.com/openfaas/of-watchdog/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestNewStatsDServer_PassesAddressToFunction(t *testing.T) {
+	cfg := config.WatchdogConfig{
+		StatsDAddress:   "127.0.0.1:0",
+		StatsDPrefix:    "custom",
+		StatsDMaxSeries: 10,
+	}
+
+	reg := prometheus.NewRegistry()
+	server, err := newStatsDServer(cfg, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Listen(cfg.StatsDAddress); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	server.Add("orders:1|c")
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, family := range families {
+		found = found || family.GetName() == "custom_orders"
+	}
+	if !found {
+		t.Errorf("want custom_orders to be gathered")
+	}
+
+	_, port, _ := net.SplitHostPort(server.Addr().String())
+	env := statsDEnvironment(server.Addr())
+	want := []string{"STATSD_ADDR=127.0.0.1:" + port, "DD_AGENT_HOST=127.0.0.1", "DD_DOGSTATSD_PORT=" + port}
+	if len(env) != len(want) {
+		t.Fatalf("want env: %v, got: %v", want, env)
+	}
+	for i := range want {
+		if env[i] != want[i] {
+			t.Errorf("want env: %v, got: %v", want, env)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/openfaas/faas-provider/httputil"
+	"github.com/openfaas/of-watchdog/config"
+	"github.com/openfaas/of-watchdog/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/openfaas/of-watchdog"
+
+// newTracerProvider creates a provider which exports spans over
+// OTLP/HTTP to the configured endpoint, in batches.
+func newTracerProvider(ctx context.Context, cfg config.WatchdogConfig) (*sdktrace.TracerProvider, error) {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(cfg.TraceOTLPEndpoint))
+	if err != nil {
+		return nil, fmt.Errorf("error creating OTLP trace exporter: %w", err)
+	}
+
+	serviceName := "of-watchdog"
+	if name, err := getFnName(); err == nil {
+		serviceName = name
+	}
+
+	attrs := []attribute.KeyValue{
+		attribute.String("service.name", serviceName),
+	}
+	if namespace, ok := os.LookupEnv("OPENFAAS_NAMESPACE"); ok {
+		attrs = append(attrs, attribute.String("service.namespace", namespace))
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attrs...)),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TraceSampleRatio))),
+	), nil
+}
+
+// makeTracingHandler starts a server span for each invocation, as a child
+// of any W3C trace context given in the traceparent and tracestate headers.
+func makeTracingHandler(next http.Handler, tracer trace.Tracer) http.Handler {
+	propagator := propagation.TraceContext{}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		attrs := []attribute.KeyValue{
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.path", r.URL.Path),
+		}
+		if callID := r.Header.Get("X-Call-Id"); len(callID) > 0 {
+			attrs = append(attrs, attribute.String("faas.invocation_id", callID))
+		}
+
+		ctx, span := tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attrs...))
+		defer span.End()
+
+		if span.SpanContext().IsSampled() {
+			metrics.SetTraceID(ctx, span.SpanContext().TraceID().String())
+		}
+
+		ww := httputil.NewHttpWriteInterceptor(w)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
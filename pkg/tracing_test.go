@@ -0,0 +1,122 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// collectorStandIn receives spans exported over OTLP/HTTP.
+type collectorStandIn struct {
+	mu    sync.Mutex
+	spans map[string]string
+}
+
+func (c *collectorStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req := &collectortrace.ExportTraceServiceRequest{}
+	if err := proto.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			for _, span := range ss.Spans {
+				c.spans[span.Name] = hex.EncodeToString(span.TraceId)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	body, _ = proto.Marshal(&collectortrace.ExportTraceServiceResponse{})
+	w.Write(body)
+}
+
+func TestTracing_ExportsServerSpanForTraceParent(t *testing.T) {
+	collector := &collectorStandIn{spans: map[string]string{}}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+
+	cfg := testConfig()
+	cfg.TraceOTLPEndpoint = srv.URL + "/v1/traces"
+	cfg.TraceSampleRatio = 1
+
+	var handlerSpan trace.SpanContext
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanContextFromContext(r.Context())
+	}
+
+	_, url, stop := startTestWatchdog(t, WithConfig(cfg), WithHandler(handler))
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req, _ := http.NewRequest(http.MethodPost, url, nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	// Stopping the watchdog flushes any spans which are batched.
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := handlerSpan.TraceID().String(); got != traceID {
+		t.Errorf("want handler to run in trace %s, got: %s", traceID, got)
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+
+	got, ok := collector.spans[http.MethodPost]
+	if !ok {
+		t.Fatalf("want a %s span exported, got: %v", http.MethodPost, collector.spans)
+	}
+	if got != traceID {
+		t.Errorf("want span in trace %s, got: %s", traceID, got)
+	}
+}
+
+func TestTracing_DisabledByDefault(t *testing.T) {
+	var handlerSpan trace.SpanContext
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanContextFromContext(r.Context())
+	}
+
+	_, url, stop := startTestWatchdog(t, WithHandler(handler))
+	defer stop()
+
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if handlerSpan.IsValid() {
+		t.Errorf("want no span when tracing is disabled, got trace: %s", handlerSpan.TraceID())
+	}
+}
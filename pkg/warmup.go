@@ -0,0 +1,73 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/openfaas/of-watchdog/config"
+)
+
+// warmupRetryInterval is the wait between attempts of a warm-up request,
+// such as whilst the function process of http mode is still starting.
+const warmupRetryInterval = 100 * time.Millisecond
+
+// warmUp sends each request to the function handler in order, retrying
+// each until it returns its expected status. An error is returned when
+// that has not happened for all of them within timeout.
+func warmUp(ctx context.Context, functionHandler http.Handler, requests []config.WarmupRequest, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	for i, req := range requests {
+		attempts := 0
+		for {
+			attempts++
+
+			status, err := warmUpOnce(ctx, functionHandler, req)
+			if err == nil && status == req.Status {
+				log.Printf("Warm-up %d/%d: %s %s returned %d after %d attempt(s)\n", i+1, len(requests), req.Method, req.Path, status, attempts)
+				break
+			}
+
+			select {
+			case <-ctx.Done():
+				if err == nil {
+					err = fmt.Errorf("want status %d, got: %d", req.Status, status)
+				}
+				return fmt.Errorf("warm-up request %s %s failed after %d attempt(s) in %s: %w", req.Method, req.Path, attempts, timeout, err)
+			case <-time.After(warmupRetryInterval):
+			}
+		}
+	}
+
+	log.Printf("Warm-up complete in %s\n", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+func warmUpOnce(ctx context.Context, functionHandler http.Handler, req config.WarmupRequest) (int, error) {
+	r, err := http.NewRequestWithContext(ctx, req.Method, req.Path, bytes.NewReader(req.Body))
+	if err != nil {
+		return 0, err
+	}
+
+	// The function invokers use the RequestURI for the upstream path,
+	// as per the readiness probe.
+	r.RequestURI = req.Path
+	for key, value := range req.Headers {
+		r.Header.Set(key, value)
+	}
+
+	rec := httptest.NewRecorder()
+	functionHandler.ServeHTTP(rec, r)
+
+	return rec.Code, nil
+}
@@ -0,0 +1,179 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openfaas/of-watchdog/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestWarmUp_RetriesUntilStatus(t *testing.T) {
+	attempts := 0
+	var body, contentType string
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		contentType = r.Header.Get("Content-Type")
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	requests := []config.WarmupRequest{{
+		Method:  http.MethodPost,
+		Path:    "/predict",
+		Headers: map[string]string{"Content-Type": "application/json"},
+		Body:    []byte(`{"text": "warm"}`),
+		Status:  http.StatusAccepted,
+	}}
+
+	if err := warmUp(context.Background(), handler, requests, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if attempts != 3 {
+		t.Errorf("want 3 attempts, got: %d", attempts)
+	}
+	if body != `{"text": "warm"}` || contentType != "application/json" {
+		t.Errorf("want the body and headers to be sent, got: %q, %q", body, contentType)
+	}
+}
+
+func TestWarmUp_FailsAfterTimeout(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	requests := []config.WarmupRequest{{Method: http.MethodGet, Path: "/", Status: http.StatusOK}}
+	if err := warmUp(context.Background(), handler, requests, 250*time.Millisecond); err == nil {
+		t.Fatal("want error when the status is never returned")
+	}
+}
+
+func TestRun_WarmupFailureIsNotReady(t *testing.T) {
+	cfg := testConfig()
+	cfg.Warmup = []config.WarmupRequest{{Method: http.MethodGet, Path: "/", Status: http.StatusOK}}
+	cfg.WarmupTimeout = 100 * time.Millisecond
+
+	w, err := New(WithConfig(cfg), WithHandler(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Run(context.Background()); err == nil {
+		t.Fatal("want error when warm-up fails")
+	}
+	if w.State() != StateFailed {
+		t.Errorf("want state %s, got: %s", StateFailed, w.State())
+	}
+}
+
+func TestRun_ServesProbesDuringWarmup(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testConfig()
+	cfg.Warmup = []config.WarmupRequest{{Method: http.MethodGet, Path: "/", Status: http.StatusOK}}
+	cfg.WarmupTimeout = 5 * time.Second
+
+	warming := make(chan struct{}, 1)
+	release := make(chan struct{})
+	w, err := New(WithConfig(cfg), WithListener(l), WithHandler(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case warming <- struct{}{}:
+			<-release
+		default:
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- w.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-errCh
+	}()
+
+	<-warming
+	url := "http://" + l.Addr().String()
+	client := &http.Client{Timeout: time.Second}
+
+	res, err := client.Get(url + "/_/state")
+	if err != nil {
+		t.Fatalf("want /_/state to be served during warm-up, got: %s", err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(body), `"state":"starting"`) {
+		t.Errorf("want state starting during warm-up, got: %s", body)
+	}
+
+	res, err = client.Get(url + "/_/ready")
+	if err != nil {
+		t.Fatalf("want /_/ready to be served during warm-up, got: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("want /_/ready to fail during warm-up, got status: %d", res.StatusCode)
+	}
+
+	close(release)
+	for i := 0; i < 50 && !w.AcceptingConnections(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !w.AcceptingConnections() {
+		t.Error("want the watchdog to be ready after warm-up")
+	}
+}
+
+func TestRun_TimeToReady(t *testing.T) {
+	cfg := testConfig()
+	cfg.Warmup = []config.WarmupRequest{{Method: http.MethodGet, Path: "/", Status: http.StatusOK}}
+	cfg.WarmupTimeout = time.Second
+
+	reg := prometheus.NewRegistry()
+	_, _, stop := startTestWatchdog(t,
+		WithConfig(cfg),
+		WithRegistry(reg),
+		WithHandler(func(w http.ResponseWriter, r *http.Request) {}))
+	defer stop()
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, family := range families {
+		if family.GetName() == "watchdog_time_to_ready_seconds" {
+			if v := family.GetMetric()[0].GetGauge().GetValue(); v <= 0 {
+				t.Errorf("want time to ready over 0s, got: %f", v)
+			}
+			return
+		}
+	}
+
+	t.Error("watchdog_time_to_ready_seconds not gathered")
+}
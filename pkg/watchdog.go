@@ -2,9 +2,11 @@ package pkg
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -12,46 +14,250 @@ import (
 	"os/signal"
 	"path/filepath"
 	"strings"
-	"sync/atomic"
+	"sync"
 	"syscall"
 	"time"
 
-	"github.com/docker/go-units"
+	"github.com/google/uuid"
 	"github.com/openfaas/faas-middleware/auth"
 	limiter "github.com/openfaas/faas-middleware/concurrency-limiter"
 	"github.com/openfaas/of-watchdog/config"
 	"github.com/openfaas/of-watchdog/executor"
+	"github.com/openfaas/of-watchdog/logsink"
 	"github.com/openfaas/of-watchdog/metrics"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
-)
-
-var (
-	acceptingConnections int32
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Watchdog struct {
-	config      config.WatchdogConfig
-	shutdownCtx context.Context
+	config     config.WatchdogConfig
+	listener   net.Listener
+	routes     []route
+	middleware []Middleware
+	registry   *prometheus.Registry
+
+	tracerProvider trace.TracerProvider
+
+	state *lifecycle
+
+	// stoppedIdle is set when the watchdog stopped after idle_timeout.
+	stoppedIdle bool
+
+	// started is when the last Run began, for the time to ready.
+	started time.Time
+
+	metricsOnce sync.Once
+	metrics     *watchdogMetrics
+	metricsErr  error
 }
 
+// NewWatchdog creates a Watchdog for the given configuration.
 func NewWatchdog(config config.WatchdogConfig) *Watchdog {
 	return &Watchdog{
 		config: config,
+		state:  newLifecycle(),
 	}
 }
 
+// New creates a Watchdog for embedding in another Go program. The
+// configuration is given with WithConfig or WithHandler, the other
+// options are applied in order.
+func New(opts ...Option) (*Watchdog, error) {
+	w := &Watchdog{
+		state: newLifecycle(),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	if w.config.OperationalMode == 0 {
+		return nil, fmt.Errorf("no watchdog mode configured, use WithConfig or WithHandler")
+	}
+
+	if w.config.OperationalMode == config.ModeInproc && w.config.Handler == nil {
+		return nil, fmt.Errorf(`for "mode=inproc" you must set a handler with WithHandler`)
+	}
+
+	if w.config.OperationalMode == config.ModeInproc {
+		if err := w.config.ValidateInproc(); err != nil {
+			return nil, err
+		}
+	}
+
+	return w, nil
+}
+
+// Start runs the watchdog until ctx is cancelled or a SIGTERM is received.
+// It is kept for compatibility, see Run.
 func (w *Watchdog) Start(ctx context.Context) error {
+	return w.Run(ctx)
+}
+
+// Run serves the function until ctx is cancelled or a SIGTERM is received,
+// then drains in-flight requests. An error is returned if the watchdog
+// could not be started or stopped serving unexpectedly, in which case
+// the watchdog moves to StateFailed.
+func (w *Watchdog) Run(ctx context.Context) error {
+	if err := w.state.Transition(StateStarting); err != nil {
+		return err
+	}
+
+	if err := w.run(ctx); err != nil {
+		w.state.Fail(err)
+		return err
+	}
+
+	return nil
+}
 
-	atomic.StoreInt32(&acceptingConnections, 0)
+func (w *Watchdog) run(ctx context.Context) error {
+	w.started = time.Now()
+
+	// background tasks are stopped when run returns
+	backgroundCtx, stopBackground := context.WithCancel(ctx)
+	defer stopBackground()
+
+	m, err := w.registerMetrics()
+	if err != nil {
+		return err
+	}
 
 	// baseFunctionHandler is the function invoker without any other middlewares.
 	// It is used to provide a generic way to implement the readiness checks regardless
 	// of the request mode.
-	baseFunctionHandler := buildRequestHandler(w.config, w.config.PrefixLogs)
+	logs := functionLogging{metrics: m.logs}
+
+	if w.config.LogRateLimit > 0 {
+		logs.limiter = executor.NewLogLimiter(executor.LogLimitOptions{
+			Rate:            w.config.LogRateLimit,
+			Burst:           w.config.LogRateBurst,
+			Sample:          w.config.LogRateSample,
+			ErrorPattern:    w.config.LogErrorPattern,
+			SummaryInterval: w.config.LogRateSummaryInterval,
+		}, logs.metrics, time.Now)
+
+		go logs.limiter.Run(backgroundCtx)
+	}
+
+	sink, closeSinks, err := newLogSink(w.config)
+	if err != nil {
+		return err
+	}
+	defer closeSinks()
+	logs.sink = sink
+
+	fn := functionRuntime{
+		logs:    logs,
+		metrics: m.function,
+		process: &functionProcess{},
+	}
+
+	if server := m.statsd; server != nil {
+		if err := server.Listen(w.config.StatsDAddress); err != nil {
+			return err
+		}
+
+		go server.Run(backgroundCtx)
+
+		fn.env = statsDEnvironment(server.Addr())
+		log.Printf("StatsD listening on: %s\n", server.Addr())
+	}
+
+	baseFunctionHandler, err := buildRequestHandler(w.config, w.config.PrefixLogs, fn)
+	if err != nil {
+		return err
+	}
+
+	if runner := fn.process.runner; runner != nil {
+		// The collector reads the process of this Run, so is removed
+		// when it returns. Another watchdog sharing the registry may
+		// have registered its own, in which case only the watcher
+		// below uses it.
+		procCollector := metrics.NewProcCollector(procRoot, runner.Pid, time.Now)
+		if err := m.registerer.Register(procCollector); err == nil {
+			defer m.registerer.Unregister(procCollector)
+		} else if !errors.As(err, &prometheus.AlreadyRegisteredError{}) {
+			return err
+		}
+
+		if w.config.ProcessMaxRSS > 0 {
+			watcher := &processWatcher{
+				usage:    procCollector.Usage,
+				restart:  func() error { return runner.Restart(restartGracePeriod) },
+				maxRSS:   w.config.ProcessMaxRSS,
+				interval: w.config.ProcessCheckInterval,
+				restarts: fn.metrics.Restarts,
+			}
+
+			go watcher.Run(backgroundCtx)
+		}
+	}
 	requestHandler := baseFunctionHandler
 
+	httpMetrics := m.http
+
+	var limit limiter.Limiter
+	if w.config.MaxInflightMode == config.MaxInflightAdaptive {
+		initial := w.config.MaxInflight
+		if initial <= 0 {
+			initial = w.config.AdaptiveMinInflight
+		}
+
+		requestLimiter := newAdaptiveLimiter(requestHandler,
+			initial,
+			w.config.AdaptiveMinInflight,
+			w.config.AdaptiveMaxInflight,
+			w.config.AdaptiveWindow,
+			w.config.AdaptiveLatencyTolerance,
+			&httpMetrics,
+			time.Now)
+		requestHandler = requestLimiter
+		limit = requestLimiter
+	} else if w.config.MaxInflight > 0 && w.config.PriorityLanes {
+		requestLimiter := newPriorityLimiter(requestHandler, w.config, &httpMetrics)
+		requestHandler = requestLimiter
+		limit = requestLimiter
+	} else if w.config.MaxInflight > 0 && w.config.MaxInflightQueue > 0 {
+		requestLimiter := newQueueLimiter(requestHandler,
+			w.config.MaxInflight,
+			w.config.MaxInflightQueue,
+			w.config.MaxInflightQueueTimeout,
+			&httpMetrics)
+		requestHandler = requestLimiter
+		limit = requestLimiter
+	} else if w.config.MaxInflight > 0 {
+		requestLimiter := limiter.NewConcurrencyLimiter(requestHandler, w.config.MaxInflight)
+		requestHandler = countConcurrencyRejections(requestLimiter.Handler(), &httpMetrics)
+		limit = requestLimiter
+	}
+
+	if w.config.MaxInflight > 0 && w.config.MaxInflightMode != config.MaxInflightAdaptive {
+		httpMetrics.InFlightLimit.Set(float64(w.config.MaxInflight))
+	}
+
+	var memory *memoryGuard
+	if w.config.MemoryHighWatermark > 0 {
+		memory = newMemoryGuard(w.config.MemoryCgroupPath,
+			w.config.MemoryHighWatermark,
+			w.config.MemoryLowWatermark,
+			w.config.MemoryCheckInterval)
+		memory.metrics = &httpMetrics
+
+		go memory.Run(backgroundCtx)
+
+		requestHandler = memory.Handler(requestHandler)
+	}
+
+	if len(w.config.LimitRules) > 0 {
+		requestHandler = newRuleLimiter(requestHandler, w.config.LimitRules, &httpMetrics, time.Now)
+	}
+
+	// The token is verified outside of the limiters, so that a forged
+	// token is rejected before it takes a slot, and priority_claim is
+	// only read from a verified token.
 	if w.config.JWTAuthentication {
-		handler, err := makeJWTAuthHandler(w.config, baseFunctionHandler)
+		handler, err := makeJWTAuthHandler(w.config, requestHandler)
 		if err != nil {
 			return fmt.Errorf("error creating JWTAuthMiddleware: %w", err)
 		}
@@ -59,36 +265,113 @@ func (w *Watchdog) Start(ctx context.Context) error {
 		requestHandler = handler
 	}
 
-	var limit limiter.Limiter
-	if w.config.MaxInflight > 0 {
-		requestLimiter := limiter.NewConcurrencyLimiter(requestHandler, w.config.MaxInflight)
-		requestHandler = requestLimiter.Handler()
-		limit = requestLimiter
+	for i := len(w.middleware) - 1; i >= 0; i-- {
+		requestHandler = w.middleware[i](requestHandler)
 	}
 
+	tracerProvider := w.tracerProvider
+	if tracerProvider == nil && len(w.config.TraceOTLPEndpoint) > 0 {
+		tp, err := newTracerProvider(backgroundCtx, w.config)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), w.config.HTTPWriteTimeout)
+			defer cancel()
+
+			if err := tp.Shutdown(shutdownCtx); err != nil {
+				log.Printf("Error flushing traces: %s\n", err)
+			}
+		}()
+
+		tracerProvider = tp
+	}
+
+	if tracerProvider != nil {
+		requestHandler = makeTracingHandler(requestHandler, tracerProvider.Tracer(tracerName))
+	}
+
+	var accessLogOutput io.Writer = os.Stderr
+	if sink != nil {
+		accessLogOutput = logsink.Tee(os.Stderr, sink)
+	}
+
+	accessLogger := newAccessLogger(accessLogOutput, w.config.AccessLogFormat, w.config.LogCallId)
+	requestHandler = makeAccessLogHandler(requestHandler,
+		accessLogger,
+		config.WatchdogMode(w.config.OperationalMode),
+		w.config.AccessLogSkipUserAgents)
+
 	log.Printf("Watchdog mode: %s\tfprocess: %q\n", config.WatchdogMode(w.config.OperationalMode), w.config.FunctionProcess)
 
-	httpMetrics := metrics.NewHttp()
-	http.HandleFunc("/", metrics.InstrumentHandler(requestHandler, httpMetrics))
-	http.HandleFunc("/_/health", makeHealthHandler(w.LockFilePresent))
-	http.Handle("/_/ready", &readiness{
+	checks := newBuiltinChecks(w.AcceptingConnections, w.LockFilePresent, limit, memory)
+	if runner := fn.process.runner; runner != nil {
+		checks.add(upstreamProcessCheck(runner.Running))
+	}
+	for _, check := range w.config.HealthChecks {
+		checks.add(newHealthCheck(check))
+	}
+
+	// readyEndpoint is only probed by /_/ready when it is not probed
+	// in the background.
+	readyEndpoint := w.config.ReadyEndpoint
+	if len(readyEndpoint) > 0 && w.config.ReadyInterval > 0 {
+		checks.add(readyProbeCheck(baseFunctionHandler, w.config))
+		readyEndpoint = ""
+	}
+
+	go checks.Run(backgroundCtx)
+
+	var functionRoute http.Handler = metrics.InstrumentHandler(requestHandler, httpMetrics)
+
+	var idle <-chan struct{}
+	if w.config.IdleTimeout > 0 {
+		timer := newIdleTimer(w.config.IdleTimeout, time.Now)
+		functionRoute = timer.Handler(functionRoute)
+		idle = timer.Idle()
+
+		go timer.Run(backgroundCtx)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", functionRoute)
+	mux.HandleFunc("/_/health", makeHealthHandler(checks))
+	mux.Handle("/_/ready", &readiness{
 		// make sure to pass original handler, before it's been wrapped by
 		// the limiter
 		functionHandler: baseFunctionHandler,
-		endpoint:        w.config.ReadyEndpoint,
-		lockCheck:       w.LockFilePresent,
-		limiter:         limit,
+		endpoint:        readyEndpoint,
+		checks:          checks,
+		timeout:         w.config.ReadyTimeout,
 	})
+	mux.Handle("/_/state", w.state)
+	mux.HandleFunc("/_/load", makeLoadHandler(&httpMetrics))
 
-	metricsServer := metrics.MetricsServer{}
-	metricsServer.Register(w.config.MetricsPort)
+	for _, r := range w.routes {
+		mux.Handle(r.pattern, r.handler)
+	}
 
-	cancel := make(chan bool)
+	if w.config.MetricsPort > 0 {
+		metricsServer := metrics.MetricsServer{
+			Gatherer:     m.registry,
+			ReadTimeout:  w.config.MetricsReadTimeout,
+			WriteTimeout: w.config.MetricsWriteTimeout,
+			OpenMetrics:  w.config.MetricsExemplars,
+		}
+		metricsServer.Register(w.config.MetricsPort)
 
-	go metricsServer.Serve(cancel)
+		if err := metricsServer.Listen(); err != nil {
+			return err
+		}
+
+		cancel := make(chan bool)
+		defer close(cancel)
+
+		go metricsServer.Serve(cancel)
+	}
 
 	s := &http.Server{
-		Addr:           fmt.Sprintf(":%d", w.config.TCPPort),
+		Handler:        mux,
 		ReadTimeout:    w.config.HTTPReadTimeout,
 		WriteTimeout:   w.config.HTTPWriteTimeout,
 		MaxHeaderBytes: 1 << 20, // Max header of 1MB
@@ -104,123 +387,181 @@ func (w *Watchdog) Start(ctx context.Context) error {
 		log.Printf("JWT Auth: %v\n", w.config.JWTAuthentication)
 	}
 
-	log.Printf("Listening on port: %d\n", w.config.TCPPort)
+	l := w.listener
+	if l == nil {
+		l, err = net.Listen("tcp", fmt.Sprintf(":%d", w.config.TCPPort))
+		if err != nil {
+			return fmt.Errorf("unable to listen on port %d: %w", w.config.TCPPort, err)
+		}
 
-	listenUntilShutdown(s,
-		ctx,
-		w.config.HealthcheckInterval,
-		w.config.HTTPWriteTimeout,
-		w.config.SuppressLock,
-		&httpMetrics)
+		log.Printf("Listening on port: %d\n", w.config.TCPPort)
+	} else {
+		log.Printf("Listening on: %s\n", l.Addr())
+	}
 
-	return nil
+	return w.listenUntilShutdown(ctx, s, l, &httpMetrics, idle, baseFunctionHandler)
+}
+
+// AcceptingConnections returns true whilst the watchdog is in StateReady.
+func (w *Watchdog) AcceptingConnections() bool {
+	return w.state.Current() == StateReady
 }
 
-func markUnhealthy() error {
-	atomic.StoreInt32(&acceptingConnections, 0)
+// State returns the current lifecycle state of the watchdog.
+func (w *Watchdog) State() State {
+	return w.state.Current()
+}
 
-	path := filepath.Join(os.TempDir(), ".lock")
+// StoppedIdle returns true when the watchdog stopped because no requests
+// were made for the idle_timeout.
+func (w *Watchdog) StoppedIdle() bool {
+	return w.stoppedIdle
+}
+
+// StateError returns the error which moved the watchdog to StateFailed,
+// or nil when it has not failed.
+func (w *Watchdog) StateError() error {
+	return w.state.Err()
+}
+
+func (w *Watchdog) markUnhealthy() error {
+	if err := w.state.Transition(StateDraining); err != nil {
+		log.Printf("Unable to move to %s state: %s\n", StateDraining, err)
+	}
+
+	path := w.lockPath()
 	log.Printf("Removing lock-file : %s\n", path)
 	removeErr := os.Remove(path)
 	return removeErr
 }
 
-func listenUntilShutdown(s *http.Server, shutdownCtx context.Context, healthcheckInterval time.Duration, writeTimeout time.Duration, suppressLock bool, httpMetrics *metrics.Http) error {
+// listenUntilShutdown serves until shutdownCtx is cancelled, a SIGTERM is
+// received or idle is closed, then drains in-flight requests. Any warm-up
+// requests are sent to functionHandler before the watchdog is ready.
+func (w *Watchdog) listenUntilShutdown(shutdownCtx context.Context, s *http.Server, l net.Listener, httpMetrics *metrics.Http, idle <-chan struct{}, functionHandler http.Handler) error {
+	healthcheckInterval := w.config.HealthcheckInterval
 
-	idleConnsClosed := make(chan struct{})
-	go func() {
-		sig := make(chan os.Signal, 1)
-		signal.Notify(sig, syscall.SIGTERM)
+	serveErr := make(chan error, 1)
 
-		reason := ""
+	// Run the HTTP server in a separate go-routine.
+	go func() {
+		if err := s.Serve(l); err != http.ErrServerClosed {
+			serveErr <- err
+		}
+	}()
 
-		select {
-		case <-sig:
-			reason = "SIGTERM"
-		case <-shutdownCtx.Done():
-			reason = "Context cancelled"
+	// The function is warmed up whilst serving, so that the health,
+	// readiness and state endpoints respond in the meantime. The lock
+	// file is only written once the function is warm.
+	if len(w.config.Warmup) > 0 {
+		if err := warmUp(shutdownCtx, functionHandler, w.config.Warmup, w.config.WarmupTimeout); err != nil {
+			s.Close()
+			return err
 		}
+	}
 
-		log.Printf("%s: no new connections in %s\n", reason, healthcheckInterval.String())
+	if w.config.SuppressLock == false {
+		path, writeErr := createLockFile(w.lockPath())
 
-		if err := markUnhealthy(); err != nil {
-			log.Printf("Unable to mark server as unhealthy: %s\n", err.Error())
+		if writeErr != nil {
+			s.Close()
+			return fmt.Errorf("cannot write %s. To disable lock-file set env suppress_lock=true: %w", path, writeErr)
 		}
+	} else {
+		log.Println("Warning: \"suppress_lock\" is enabled. No automated health-checks will be in place for your function.")
+	}
 
-		<-time.Tick(healthcheckInterval)
+	if err := w.state.Transition(StateReady); err != nil {
+		s.Close()
+		return err
+	}
 
-		connections := int64(testutil.ToFloat64(httpMetrics.InFlight))
-		log.Printf("No new connections allowed, draining: %d requests\n", connections)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM)
+	defer signal.Stop(sig)
 
-		// The maximum time to wait for active connections whilst shutting down is
-		// equivalent to the maximum execution time i.e. writeTimeout.
-		ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
-		defer cancel()
+	reason := ""
 
-		if err := s.Shutdown(ctx); err != nil {
-			log.Printf("Error in Shutdown: %v", err)
-		}
+	select {
+	case err := <-serveErr:
+		w.markUnhealthy()
+		return fmt.Errorf("error serving HTTP: %w", err)
+	case <-sig:
+		reason = "SIGTERM"
+	case <-shutdownCtx.Done():
+		reason = "Context cancelled"
+	case <-idle:
+		reason = fmt.Sprintf("No requests for idle_timeout: %s", w.config.IdleTimeout)
+		w.stoppedIdle = true
+	}
 
-		connections = int64(testutil.ToFloat64(httpMetrics.InFlight))
+	log.Printf("%s: no new connections in %s\n", reason, healthcheckInterval.String())
 
-		log.Printf("Exiting. Active connections: %d\n", connections)
+	if err := w.markUnhealthy(); err != nil {
+		log.Printf("Unable to mark server as unhealthy: %s\n", err.Error())
+	}
 
-		close(idleConnsClosed)
-	}()
+	<-time.After(healthcheckInterval)
 
-	// Run the HTTP server in a separate go-routine.
-	go func() {
-		if err := s.ListenAndServe(); err != http.ErrServerClosed {
-			log.Printf("Error ListenAndServe: %v", err)
-			close(idleConnsClosed)
-		}
-	}()
+	connections := int64(testutil.ToFloat64(httpMetrics.InFlight))
+	log.Printf("No new connections allowed, draining: %d requests\n", connections)
 
-	if suppressLock == false {
-		path, writeErr := createLockFile()
+	// The maximum time to wait for active connections whilst shutting down is
+	// equivalent to the maximum execution time i.e. writeTimeout.
+	ctx, cancel := context.WithTimeout(context.Background(), w.config.HTTPWriteTimeout)
+	defer cancel()
 
-		if writeErr != nil {
-			return fmt.Errorf("cannot write %s. To disable lock-file set env suppress_lock=true: %w", path, writeErr)
-		}
-	} else {
-		log.Println("Warning: \"suppress_lock\" is enabled. No automated health-checks will be in place for your function.")
+	if err := s.Shutdown(ctx); err != nil {
+		log.Printf("Error in Shutdown: %v", err)
+	}
+
+	connections = int64(testutil.ToFloat64(httpMetrics.InFlight))
+
+	log.Printf("Exiting. Active connections: %d\n", connections)
 
-		atomic.StoreInt32(&acceptingConnections, 1)
+	if err := w.state.Transition(StateStopped); err != nil {
+		return err
 	}
 
-	<-idleConnsClosed
+	if w.stoppedIdle && len(w.config.IdleHook) > 0 {
+		runIdleHook(w.config.IdleHook)
+	}
 
 	return nil
 }
 
-func buildRequestHandler(cfg config.WatchdogConfig, prefixLogs bool) http.Handler {
+func buildRequestHandler(cfg config.WatchdogConfig, prefixLogs bool, fn functionRuntime) (http.Handler, error) {
 	var requestHandler http.HandlerFunc
+	var err error
 
 	switch cfg.OperationalMode {
 	case config.ModeStreaming:
-		requestHandler = makeStreamingRequestHandler(cfg, prefixLogs, cfg.LogBufferSize)
+		requestHandler = makeStreamingRequestHandler(cfg, prefixLogs, cfg.LogBufferSize, fn)
 	case config.ModeSerializing:
-		requestHandler = makeSerializingForkRequestHandler(cfg, prefixLogs)
+		requestHandler = makeSerializingForkRequestHandler(cfg, prefixLogs, fn)
 	case config.ModeHTTP:
-		requestHandler = makeHTTPRequestHandler(cfg, prefixLogs, cfg.LogBufferSize)
+		requestHandler, err = makeHTTPRequestHandler(cfg, prefixLogs, cfg.LogBufferSize, fn)
 	case config.ModeStatic:
-		requestHandler = makeStaticRequestHandler(cfg)
+		requestHandler, err = makeStaticRequestHandler(cfg)
 	case config.ModeInproc:
-		requestHandler = makeInprocRequestHandler(cfg, prefixLogs, cfg.LogBufferSize)
+		requestHandler, err = makeInprocRequestHandler(cfg, prefixLogs, cfg.LogBufferSize)
 	default:
-		log.Panicf("unknown watchdog mode: %d", cfg.OperationalMode)
+		return nil, fmt.Errorf("unknown watchdog mode: %d", cfg.OperationalMode)
+	}
+
+	if err != nil {
+		return nil, err
 	}
 
-	return requestHandler
+	return requestHandler, nil
 }
 
-// createLockFile returns a path to a lock file and/or an error
-// if the file could not be created.
-func createLockFile() (string, error) {
-	path := filepath.Join(os.TempDir(), ".lock")
+// createLockFile writes a lock file to path, and returns the path and/or
+// an error if the file could not be created.
+func createLockFile(path string) (string, error) {
 	log.Printf("Writing lock-file to: %s\n", path)
 
-	if err := os.MkdirAll(os.TempDir(), os.ModePerm); err != nil {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
 		return path, err
 	}
 
@@ -228,18 +569,27 @@ func createLockFile() (string, error) {
 		return path, err
 	}
 
-	atomic.StoreInt32(&acceptingConnections, 1)
 	return path, nil
 }
 
-func makeSerializingForkRequestHandler(cfg config.WatchdogConfig, logPrefix bool) func(http.ResponseWriter, *http.Request) {
+func makeSerializingForkRequestHandler(cfg config.WatchdogConfig, logPrefix bool, fn functionRuntime) func(http.ResponseWriter, *http.Request) {
 	functionInvoker := executor.SerializingForkFunctionRunner{
 		ExecTimeout:   cfg.ExecTimeout,
 		LogPrefix:     logPrefix,
 		LogBufferSize: cfg.LogBufferSize,
+		LogFormat:     cfg.LogFormat,
+		LogFields:     functionLogFields(),
+		LogCallId:     cfg.LogCallId,
+		LogOverflow:   cfg.LogOverflow,
+		LogMetrics:    fn.logs.metrics,
+		LogMultiline:  multilineOptions(cfg),
+		LogLimiter:    fn.logs.limiter,
+		LogSink:       fn.logs.sink,
+		Metrics:       fn.metrics,
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
+		id := callID(r)
 
 		var environment []string
 
@@ -247,35 +597,54 @@ func makeSerializingForkRequestHandler(cfg config.WatchdogConfig, logPrefix bool
 			environment = getEnvironment(r)
 		}
 
+		if len(fn.env) > 0 {
+			if environment == nil {
+				environment = os.Environ()
+			}
+			environment = append(environment, fn.env...)
+		}
+
 		commandName, arguments := cfg.Process()
 		req := executor.FunctionRequest{
-			Process:       commandName,
-			ProcessArgs:   arguments,
-			InputReader:   r.Body,
-			ContentLength: &r.ContentLength,
-			OutputWriter:  w,
-			Environment:   environment,
-			RequestURI:    r.RequestURI,
-			Method:        r.Method,
-			UserAgent:     r.UserAgent(),
+			Process:         commandName,
+			ProcessArgs:     arguments,
+			InputReader:     r.Body,
+			ContentLength:   &r.ContentLength,
+			OutputWriter:    w,
+			Environment:     environment,
+			RequestURI:      r.RequestURI,
+			Method:          r.Method,
+			UserAgent:       r.UserAgent(),
+			Context:         r.Context(),
+			StopWithContext: isReadinessProbe(r.Context()),
+			CallID:          id,
 		}
 
 		w.Header().Set("Content-Type", cfg.ContentType)
-		err := functionInvoker.Run(req, w)
-		if err != nil {
-			log.Println(err)
+		if err := functionInvoker.Run(req, w); err != nil {
+			recordAccessLogError(r.Context(), err)
 		}
 	}
 }
 
-func makeStreamingRequestHandler(cfg config.WatchdogConfig, prefixLogs bool, logBufferSize int) func(http.ResponseWriter, *http.Request) {
+func makeStreamingRequestHandler(cfg config.WatchdogConfig, prefixLogs bool, logBufferSize int, fn functionRuntime) func(http.ResponseWriter, *http.Request) {
 	functionInvoker := executor.StreamingFunctionRunner{
 		ExecTimeout:   cfg.ExecTimeout,
 		LogPrefix:     prefixLogs,
 		LogBufferSize: logBufferSize,
+		LogFormat:     cfg.LogFormat,
+		LogFields:     functionLogFields(),
+		LogCallId:     cfg.LogCallId,
+		LogOverflow:   cfg.LogOverflow,
+		LogMetrics:    fn.logs.metrics,
+		LogMultiline:  multilineOptions(cfg),
+		LogLimiter:    fn.logs.limiter,
+		LogSink:       fn.logs.sink,
+		Metrics:       fn.metrics,
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
+		id := callID(r)
 
 		var environment []string
 
@@ -283,39 +652,40 @@ func makeStreamingRequestHandler(cfg config.WatchdogConfig, prefixLogs bool, log
 			environment = getEnvironment(r)
 		}
 
+		if len(fn.env) > 0 {
+			if environment == nil {
+				environment = os.Environ()
+			}
+			environment = append(environment, fn.env...)
+		}
+
 		ww := WriterCounter{}
 		ww.setWriter(w)
-		start := time.Now()
 		commandName, arguments := cfg.Process()
 		req := executor.FunctionRequest{
-			Process:      commandName,
-			ProcessArgs:  arguments,
-			InputReader:  r.Body,
-			OutputWriter: &ww,
-			Environment:  environment,
-			RequestURI:   r.RequestURI,
-			Method:       r.Method,
-			UserAgent:    r.UserAgent(),
+			Process:         commandName,
+			ProcessArgs:     arguments,
+			InputReader:     r.Body,
+			OutputWriter:    &ww,
+			Environment:     environment,
+			RequestURI:      r.RequestURI,
+			Method:          r.Method,
+			UserAgent:       r.UserAgent(),
+			Context:         r.Context(),
+			StopWithContext: isReadinessProbe(r.Context()),
+			CallID:          id,
 		}
 
 		w.Header().Set("Content-Type", cfg.ContentType)
-		err := functionInvoker.Run(req)
-		if err != nil {
-			log.Println(err.Error())
+		if err := functionInvoker.Run(req); err != nil {
+			recordAccessLogError(r.Context(), err)
 
-			// Cannot write a status code to the client because we
-			// already have written a header
-			done := time.Since(start)
-			if !strings.HasPrefix(req.UserAgent, "kube-probe") {
-				log.Printf("%s %s - %d - ContentLength: %s (%.4fs)", req.Method, req.RequestURI, http.StatusInternalServerError, units.HumanSize(float64(ww.Bytes())), done.Seconds())
-				return
+			// A status code can only be sent when the function has not
+			// written anything, otherwise the 200 has already gone out.
+			if ww.Bytes() == 0 {
+				w.WriteHeader(http.StatusInternalServerError)
 			}
 		}
-
-		done := time.Since(start)
-		if !strings.HasPrefix(req.UserAgent, "kube-probe") {
-			log.Printf("%s %s - %d - ContentLength: %s (%.4fs)", req.Method, req.RequestURI, http.StatusOK, units.HumanSize(float64(ww.Bytes())), done.Seconds())
-		}
 	}
 }
 
@@ -344,24 +714,23 @@ func getEnvironment(r *http.Request) []string {
 	return envs
 }
 
-func makeInprocRequestHandler(cfg config.WatchdogConfig, prefixLogs bool, logBufferSize int) func(http.ResponseWriter, *http.Request) {
+func makeInprocRequestHandler(cfg config.WatchdogConfig, prefixLogs bool, logBufferSize int) (http.HandlerFunc, error) {
 	runner := executor.NewInprocRunner(cfg.Handler,
 		prefixLogs,
 		logBufferSize,
-		cfg.LogCallId,
 		cfg.ExecTimeout,
 	)
 
 	if err := runner.Start(); err != nil {
-		log.Fatalf("Failed to start in-process runner: %v", err)
+		return nil, fmt.Errorf("failed to start in-process runner: %w", err)
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		runner.Run(w, r)
-	}
+	}, nil
 }
 
-func makeHTTPRequestHandler(cfg config.WatchdogConfig, prefixLogs bool, logBufferSize int) func(http.ResponseWriter, *http.Request) {
+func makeHTTPRequestHandler(cfg config.WatchdogConfig, prefixLogs bool, logBufferSize int, fn functionRuntime) (http.HandlerFunc, error) {
 	upstreamURL, _ := url.Parse(cfg.UpstreamURL)
 
 	commandName, arguments := cfg.Process()
@@ -372,7 +741,15 @@ func makeHTTPRequestHandler(cfg config.WatchdogConfig, prefixLogs bool, logBuffe
 		BufferHTTPBody: cfg.BufferHTTPBody,
 		LogPrefix:      prefixLogs,
 		LogBufferSize:  logBufferSize,
-		LogCallId:      cfg.LogCallId,
+		LogFormat:      cfg.LogFormat,
+		LogFields:      functionLogFields(),
+		LogOverflow:    cfg.LogOverflow,
+		LogMetrics:     fn.logs.metrics,
+		LogMultiline:   multilineOptions(cfg),
+		LogLimiter:     fn.logs.limiter,
+		LogSink:        fn.logs.sink,
+		Metrics:        fn.metrics,
+		Environment:    fn.env,
 		ReverseProxy: &httputil.ReverseProxy{
 			Director: func(req *http.Request) {
 				req.URL.Host = upstreamURL.Host
@@ -385,18 +762,24 @@ func makeHTTPRequestHandler(cfg config.WatchdogConfig, prefixLogs bool, logBuffe
 	}
 
 	if len(cfg.UpstreamURL) == 0 {
-		log.Fatal(`For "mode=http" you must specify a valid URL for "http_upstream_url"`)
+		return nil, fmt.Errorf(`for "mode=http" you must specify a valid URL for "http_upstream_url"`)
 	}
 
 	urlValue, err := url.Parse(cfg.UpstreamURL)
 	if err != nil {
-		log.Fatalf(`For "mode=http" you must specify a valid URL for "http_upstream_url", error: %s`, err)
+		return nil, fmt.Errorf(`for "mode=http" you must specify a valid URL for "http_upstream_url", error: %w`, err)
 	}
 
 	functionInvoker.UpstreamURL = urlValue
 
 	log.Printf("Forking: %s, arguments: %s", commandName, arguments)
-	functionInvoker.Start()
+	if err := functionInvoker.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start function process: %w", err)
+	}
+
+	if fn.process != nil {
+		fn.process.runner = &functionInvoker
+	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -411,27 +794,37 @@ func makeHTTPRequestHandler(cfg config.WatchdogConfig, prefixLogs bool, logBuffe
 		}
 
 		if err := functionInvoker.Run(req, r.ContentLength, r, w); err != nil {
+			recordAccessLogError(r.Context(), err)
 			w.WriteHeader(500)
 			w.Write([]byte(err.Error()))
 		}
-	}
+	}, nil
 }
 
-func makeStaticRequestHandler(cfg config.WatchdogConfig) http.HandlerFunc {
+func makeStaticRequestHandler(cfg config.WatchdogConfig) (http.HandlerFunc, error) {
 	if cfg.StaticPath == "" {
-		log.Fatal(`For mode=static you must specify the "static_path" to serve`)
+		return nil, fmt.Errorf(`for mode=static you must specify the "static_path" to serve`)
 	}
 
 	log.Printf("Serving files at: %s", cfg.StaticPath)
-	return http.FileServer(http.Dir(cfg.StaticPath)).ServeHTTP
+	return http.FileServer(http.Dir(cfg.StaticPath)).ServeHTTP, nil
 }
 
 func (w *Watchdog) LockFilePresent() bool {
-	return lockFilePresent()
+	return lockFilePresent(w.lockPath())
 }
 
-func lockFilePresent() bool {
-	path := filepath.Join(os.TempDir(), ".lock")
+// lockPath is the configured lock_path, or the default for a config
+// which was not created by config.New.
+func (w *Watchdog) lockPath() string {
+	if len(w.config.LockPath) > 0 {
+		return w.config.LockPath
+	}
+
+	return config.DefaultLockPath()
+}
+
+func lockFilePresent(path string) bool {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		return false
 	}
@@ -439,12 +832,21 @@ func lockFilePresent() bool {
 	return true
 }
 
-func makeHealthHandler(lockPresent func() bool) func(http.ResponseWriter, *http.Request) {
+// makeHealthHandler reports the liveness checks, with a JSON report for
+// each check when ?verbose is given.
+func makeHealthHandler(checks *checkRegistry) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
-			if atomic.LoadInt32(&acceptingConnections) == 0 || lockPresent() == false {
-				w.WriteHeader(http.StatusServiceUnavailable)
+			status, results := checks.evaluate(r.Context(), true)
+
+			if verbose(r) {
+				writeCheckReport(w, status, results)
+				return
+			}
+
+			if status != http.StatusOK {
+				w.WriteHeader(status)
 				return
 			}
 
@@ -509,6 +911,65 @@ func getFnName() (string, error) {
 	return name, nil
 }
 
+// callID returns the X-Call-Id of the request, generating one for
+// requests which did not come through the gateway. A generated ID is
+// set on the request, so the access log records the same value.
+func callID(r *http.Request) string {
+	id := r.Header.Get("X-Call-Id")
+	if len(id) == 0 {
+		id = uuid.NewString()
+		r.Header.Set("X-Call-Id", id)
+	}
+
+	return id
+}
+
+// multilineOptions returns nil unless log_multiline is enabled.
+func multilineOptions(cfg config.WatchdogConfig) *executor.MultilineOptions {
+	if !cfg.LogMultiline {
+		return nil
+	}
+
+	return &executor.MultilineOptions{
+		Start:    cfg.LogMultilineStart,
+		Continue: cfg.LogMultilineContinue,
+		Timeout:  cfg.LogMultilineTimeout,
+		MaxBytes: cfg.LogMultilineMaxBytes,
+	}
+}
+
+// functionLogFields returns the function's name and namespace, when
+// known, to be added to structured logs from the function.
+func functionLogFields() map[string]string {
+	fields := map[string]string{}
+
+	if name, err := getFnName(); err == nil {
+		fields["function"] = name
+	}
+
+	if namespace, err := getFnNamespace(); err == nil && len(namespace) > 0 {
+		fields["namespace"] = strings.TrimSpace(namespace)
+	}
+
+	return fields
+}
+
+// functionLabels returns the constant labels for the function's name
+// and namespace, where they are known.
+func functionLabels() prometheus.Labels {
+	fields := functionLogFields()
+
+	labels := prometheus.Labels{}
+	if name, ok := fields["function"]; ok {
+		labels["function_name"] = name
+	}
+	if namespace, ok := fields["namespace"]; ok {
+		labels["function_namespace"] = namespace
+	}
+
+	return labels
+}
+
 // getFnNamespace gets the namespace name from the env variable OPENFAAS_NAMESPACE
 // or reads it from the service account if the env variable is not present
 func getFnNamespace() (string, error) {
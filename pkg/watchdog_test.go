@@ -0,0 +1,281 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/openfaas/of-watchdog/config"
+	"github.com/openfaas/of-watchdog/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func testConfig() config.WatchdogConfig {
+	return config.WatchdogConfig{
+		HTTPReadTimeout:  time.Second,
+		HTTPWriteTimeout: time.Second,
+		ExecTimeout:      time.Second,
+		SuppressLock:     true,
+	}
+}
+
+func startTestWatchdog(t *testing.T, opts ...Option) (*Watchdog, string, func() error) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := New(append([]Option{WithConfig(testConfig()), WithListener(l)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- w.Run(ctx)
+	}()
+
+	for i := 0; i < 50 && !w.AcceptingConnections(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stop := func() error {
+		cancel()
+		return <-errCh
+	}
+
+	return w, "http://" + l.Addr().String(), stop
+}
+
+func TestNew_RequiresConfig(t *testing.T) {
+	if _, err := New(); err == nil {
+		t.Fatal("want error when no config or handler is given")
+	}
+}
+
+func TestNew_InprocRequiresHandler(t *testing.T) {
+	cfg := testConfig()
+	cfg.OperationalMode = config.ModeInproc
+
+	if _, err := New(WithConfig(cfg)); err == nil {
+		t.Fatal("want error when no handler is given for inproc mode")
+	}
+}
+
+func TestNew_InprocRejectsFunctionLogOptions(t *testing.T) {
+	cfg := testConfig()
+	cfg.LogFormat = config.LogFormatJSON
+
+	if _, err := New(WithConfig(cfg), WithHandler(func(w http.ResponseWriter, r *http.Request) {})); err == nil {
+		t.Fatal("want error for log_format=json in inproc mode")
+	}
+}
+
+func TestRun_TwoWatchdogsCoexist(t *testing.T) {
+	handlerFor := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, name)
+		}
+	}
+
+	_, urlA, stopA := startTestWatchdog(t, WithHandler(handlerFor("a")))
+	_, urlB, stopB := startTestWatchdog(t, WithHandler(handlerFor("b")))
+
+	for want, url := range map[string]string{"a": urlA, "b": urlB} {
+		res, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+
+		if got := string(body); got != want {
+			t.Errorf("want body %q, got %q", want, got)
+		}
+	}
+
+	if err := stopA(); err != nil {
+		t.Errorf("want nil error from Run, got: %s", err)
+	}
+	if err := stopB(); err != nil {
+		t.Errorf("want nil error from Run, got: %s", err)
+	}
+}
+
+func TestRun_TwoWatchdogsShareRegistry(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+
+	_, urlA, stopA := startTestWatchdog(t, WithRegistry(reg), WithHandler(handler))
+	defer stopA()
+	_, urlB, stopB := startTestWatchdog(t, WithRegistry(reg), WithHandler(handler))
+	defer stopB()
+
+	for _, url := range []string{urlA, urlB} {
+		res, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+
+	if _, err := reg.Gather(); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(metrics.NewHttp(reg, metrics.HttpOptions{}).RequestsTotal.WithLabelValues("200", "get")); got != 2 {
+		t.Errorf("want 2 requests counted by both watchdogs, got: %f", got)
+	}
+}
+
+func TestRun_Again(t *testing.T) {
+	w, err := New(WithConfig(testConfig()),
+		WithRegistry(prometheus.NewRegistry()),
+		WithHandler(func(w http.ResponseWriter, r *http.Request) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 2; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- w.Run(ctx)
+		}()
+
+		for j := 0; j < 50 && !w.AcceptingConnections(); j++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if !w.AcceptingConnections() {
+			t.Fatalf("run %d: want the watchdog to be ready", i)
+		}
+
+		cancel()
+		if err := <-errCh; err != nil {
+			t.Fatalf("run %d: want nil error from Run, got: %s", i, err)
+		}
+	}
+
+	// The state gauge and time to ready hooks are only added once.
+	if got := len(w.state.hooks); got != 2 {
+		t.Errorf("want 2 state hooks, got: %d", got)
+	}
+}
+
+func TestRun_RoutesAndMiddleware(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("X-Middleware"))
+	}
+
+	middleware := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Set("X-Middleware", "called")
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	route := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	_, url, stop := startTestWatchdog(t,
+		WithHandler(handler),
+		WithMiddleware(middleware),
+		WithRoute("/_/custom", route))
+	defer stop()
+
+	res, err := http.Get(url + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+
+	if got := string(body); got != "called" {
+		t.Errorf("want middleware to be called, got body: %q", got)
+	}
+
+	res, err = http.Get(url + "/_/custom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusTeapot {
+		t.Errorf("want status %d from route, got: %d", http.StatusTeapot, res.StatusCode)
+	}
+}
+
+func TestRun_ReturnsListenError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	cfg := testConfig()
+	cfg.TCPPort = l.Addr().(*net.TCPAddr).Port
+
+	w, err := New(WithConfig(cfg), WithHandler(func(w http.ResponseWriter, r *http.Request) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Run(context.Background()); err == nil {
+		t.Fatal("want error when the port is already in use")
+	}
+}
+
+func TestRun_FunctionLabels(t *testing.T) {
+	t.Setenv("OPENFAAS_NAME", "figlet")
+	t.Setenv("OPENFAAS_NAMESPACE", "openfaas-fn")
+
+	cfg := testConfig()
+	cfg.MetricsFunctionLabels = true
+
+	reg := prometheus.NewRegistry()
+	_, url, stop := startTestWatchdog(t,
+		WithConfig(cfg),
+		WithRegistry(reg),
+		WithHandler(func(w http.ResponseWriter, r *http.Request) {}))
+	defer stop()
+
+	res, err := http.Get(url + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, family := range families {
+		if family.GetName() != "http_requests_total" {
+			continue
+		}
+
+		labels := map[string]string{}
+		for _, label := range family.GetMetric()[0].GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+
+		if labels["function_name"] != "figlet" || labels["function_namespace"] != "openfaas-fn" {
+			t.Errorf("want function_name and function_namespace labels, got: %v", labels)
+		}
+		return
+	}
+
+	t.Errorf("http_requests_total not gathered")
+}
@@ -0,0 +1,56 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/openfaas/of-watchdog/config"
+	"github.com/openfaas/of-watchdog/pkg"
+)
+
+// runProbe runs "fwatchdog probe --live|--ready", for an exec probe, and
+// returns the exit code.
+func runProbe(args []string) int {
+	var live, ready bool
+	var timeout time.Duration
+
+	flags := flag.NewFlagSet("probe", flag.ExitOnError)
+	flags.BoolVar(&live, "live", false, "Probe /_/health, exit 0 when the watchdog is healthy")
+	flags.BoolVar(&ready, "ready", false, "Probe /_/ready, exit 0 when the watchdog is ready")
+	flags.DurationVar(&timeout, "timeout", 2*time.Second, "Maximum time to wait for the watchdog to respond")
+	flags.Parse(args)
+
+	if live == ready {
+		fmt.Fprintf(os.Stderr, "Use one of --live or --ready\n")
+		return 2
+	}
+
+	// Only the port is read, since the rest of the configuration may
+	// refer to files which the probe has no need to load.
+	port, err := config.Port(os.Environ())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return 1
+	}
+
+	path := "/_/health"
+	if ready {
+		path = "/_/ready"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := pkg.Probe(ctx, port, path); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return 1
+	}
+
+	return 0
+}
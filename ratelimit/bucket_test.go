@@ -0,0 +1,33 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucket_RefillsAtRate(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := NewBucket(2, 2, func() time.Time { return now })
+
+	for i := 0; i < 2; i++ {
+		if _, ok := b.Take(); !ok {
+			t.Fatalf("want token %d of the burst", i+1)
+		}
+	}
+
+	wait, ok := b.Take()
+	if ok {
+		t.Fatal("want the bucket to be empty after the burst")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("want wait of 500ms at 2 per second, got: %s", wait)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if _, ok := b.Take(); !ok {
+		t.Error("want a token after 500ms")
+	}
+}
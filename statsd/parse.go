@@ -0,0 +1,125 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package statsd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	kindCounter = "counter"
+	kindGauge   = "gauge"
+	kindTimer   = "timer"
+)
+
+// sample is one metric line, such as "orders.placed:1|c|@0.5|#region:eu".
+type sample struct {
+	name  string
+	kind  string
+	value float64
+
+	// relative is set for a gauge given as +N or -N, which changes
+	// the gauge rather than setting it.
+	relative bool
+
+	// rate is the fraction of events sampled, between 0 and 1.
+	rate float64
+
+	labelNames  []string
+	labelValues []string
+}
+
+// parseLine parses a StatsD line with optional DogStatsD tags. Timers
+// given in ms are converted to seconds, histograms and distributions
+// are recorded as they are.
+func parseLine(line string) (sample, error) {
+	s := sample{rate: 1}
+
+	nameValue, rest, ok := strings.Cut(line, "|")
+	if !ok {
+		return s, fmt.Errorf("missing type")
+	}
+
+	name, value, ok := strings.Cut(nameValue, ":")
+	if !ok || len(name) == 0 {
+		return s, fmt.Errorf("missing name or value")
+	}
+	s.name = sanitize(name)
+
+	parts := strings.Split(rest, "|")
+
+	switch parts[0] {
+	case "c":
+		s.kind = kindCounter
+	case "g":
+		s.kind = kindGauge
+		s.relative = strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-")
+	case "ms", "h", "d":
+		s.kind = kindTimer
+	default:
+		return s, fmt.Errorf("unsupported type %q", parts[0])
+	}
+
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return s, fmt.Errorf("invalid value %q", value)
+	}
+	if s.kind == kindCounter && v < 0 {
+		return s, fmt.Errorf("negative counter value %q", value)
+	}
+	if parts[0] == "ms" {
+		v = v / 1000
+	}
+	s.value = v
+
+	tags := map[string]string{}
+	for _, part := range parts[1:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			rate, err := strconv.ParseFloat(part[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return s, fmt.Errorf("invalid sample rate %q", part)
+			}
+			s.rate = rate
+		case strings.HasPrefix(part, "#"):
+			for _, tag := range strings.Split(part[1:], ",") {
+				// A tag without a value can't be a label.
+				if key, val, ok := strings.Cut(tag, ":"); ok && len(key) > 0 {
+					tags[sanitize(key)] = val
+				}
+			}
+		}
+	}
+
+	for key := range tags {
+		s.labelNames = append(s.labelNames, key)
+	}
+	sort.Strings(s.labelNames)
+
+	for _, key := range s.labelNames {
+		s.labelValues = append(s.labelValues, tags[key])
+	}
+
+	return s, nil
+}
+
+// sanitize makes a StatsD name such as "orders.placed" into a valid
+// Prometheus name, "orders_placed".
+func sanitize(name string) string {
+	out := []byte(name)
+	for i, c := range out {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			out[i] = '_'
+		}
+	}
+
+	if len(out) > 0 && out[0] >= '0' && out[0] <= '9' {
+		return "_" + string(out)
+	}
+
+	return string(out)
+}
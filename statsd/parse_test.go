@@ -0,0 +1,58 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package statsd
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseLine(t *testing.T) {
+	cases := []struct {
+		line string
+		want sample
+	}{
+		{line: "orders.placed:1|c", want: sample{name: "orders_placed", kind: kindCounter, value: 1, rate: 1}},
+		{line: "orders:2|c|@0.5", want: sample{name: "orders", kind: kindCounter, value: 2, rate: 0.5}},
+		{line: "queue:10|g", want: sample{name: "queue", kind: kindGauge, value: 10, rate: 1}},
+		{line: "queue:-3|g", want: sample{name: "queue", kind: kindGauge, value: -3, rate: 1, relative: true}},
+		{line: "render:250|ms", want: sample{name: "render", kind: kindTimer, value: 0.25, rate: 1}},
+		{line: "payload:512|h", want: sample{name: "payload", kind: kindTimer, value: 512, rate: 1}},
+		{
+			line: "orders:1|c|#region:eu,tier:free,flag",
+			want: sample{name: "orders", kind: kindCounter, value: 1, rate: 1,
+				labelNames: []string{"region", "tier"}, labelValues: []string{"eu", "free"}},
+		},
+		{line: "2xx:1|c", want: sample{name: "_2xx", kind: kindCounter, value: 1, rate: 1}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.line, func(t *testing.T) {
+			got, err := parseLine(tc.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if fmt.Sprintf("%+v", got) != fmt.Sprintf("%+v", tc.want) {
+				t.Errorf("want: %+v, got: %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestParseLine_Invalid(t *testing.T) {
+	for _, line := range []string{
+		"orders",
+		"orders:1",
+		":1|c",
+		"orders:one|c",
+		"orders:-1|c",
+		"users:alex|s",
+		"orders:1|c|@2",
+	} {
+		if _, err := parseLine(line); err == nil {
+			t.Errorf("want error for %q", line)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+// Package statsd receives custom metrics from the function over StatsD,
+// and exposes them to Prometheus.
+package statsd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/openfaas/of-watchdog/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxPacketSize is the largest UDP datagram.
+const maxPacketSize = 65535
+
+// timerSuffixes are the names of the series of a histogram, which must not
+// be the name of another family.
+var timerSuffixes = []string{"_bucket", "_count", "_sum"}
+
+// Options configure how metrics from the function are exposed.
+type Options struct {
+	// Prefix is added to the name of each metric, i.e. "custom" gives
+	// custom_orders_placed for orders.placed.
+	Prefix string
+
+	// MaxSeries is the most series which are kept, samples for any
+	// new series beyond it are dropped.
+	MaxSeries int
+
+	// Buckets are the upper bounds of the histograms for timers.
+	Buckets []float64
+
+	// ReservedLabels are label names used by the watchdog, such as
+	// its constant labels, tags with these names are dropped.
+	ReservedLabels []string
+}
+
+// Server aggregates counters, gauges and timers sent to it, and is a
+// prometheus.Collector for them.
+type Server struct {
+	opts    Options
+	metrics *metrics.StatsD
+	conn    net.PacketConn
+
+	mu       sync.Mutex
+	families map[string]*family
+	series   int
+}
+
+// family is all of the series with one name, which must have the same
+// kind and label names.
+type family struct {
+	kind       string
+	labelNames []string
+	desc       *prometheus.Desc
+	series     map[string]*series
+}
+
+type series struct {
+	labelValues []string
+
+	// value is the counter or gauge.
+	value float64
+
+	// count, sum and buckets are the timer, with the count for each
+	// bucket including all smaller values.
+	count   float64
+	sum     float64
+	buckets []float64
+}
+
+// NewServer creates a server which records its own activity in m.
+func NewServer(opts Options, m *metrics.StatsD) *Server {
+	if len(opts.Buckets) == 0 {
+		opts.Buckets = prometheus.DefBuckets
+	}
+
+	return &Server{
+		opts:     opts,
+		metrics:  m,
+		families: map[string]*family{},
+	}
+}
+
+// Listen binds address ahead of Run, so that an error such as the port
+// already being in use can be returned to the caller.
+func (s *Server) Listen(address string) error {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return fmt.Errorf("statsd unable to listen on %s: %w", address, err)
+	}
+
+	s.conn = conn
+	return nil
+}
+
+// Addr returns the address being listened on, which gives the port when
+// the address passed to Listen had a port of 0.
+func (s *Server) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Close stops listening, for a server which will not be Run.
+func (s *Server) Close() error {
+	return s.conn.Close()
+}
+
+// Run receives metrics until ctx is cancelled.
+func (s *Server) Run(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		s.conn.Close()
+	}()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Error reading statsd packet: %s", err)
+			}
+			return
+		}
+
+		for _, line := range bytes.Split(buf[:n], []byte("\n")) {
+			if line = bytes.TrimSpace(line); len(line) > 0 {
+				s.Add(string(line))
+			}
+		}
+	}
+}
+
+// Add records one StatsD line.
+func (s *Server) Add(line string) {
+	sample, err := parseLine(line)
+	if err != nil {
+		s.dropped("invalid")
+		return
+	}
+
+	// A label value must be valid UTF-8 to be scraped.
+	for _, value := range sample.labelValues {
+		if !utf8.ValidString(value) {
+			s.dropped("invalid")
+			return
+		}
+	}
+
+	sample = s.withoutReserved(sample)
+	name := sample.name
+	if len(s.opts.Prefix) > 0 {
+		name = s.opts.Prefix + "_" + name
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.families[name]
+	if !ok {
+		if s.series >= s.opts.MaxSeries {
+			s.dropped("limit")
+			return
+		}
+
+		if s.clashes(name, sample.kind) {
+			s.dropped("conflict")
+			return
+		}
+
+		f = &family{
+			kind:       sample.kind,
+			labelNames: sample.labelNames,
+			desc:       prometheus.NewDesc(name, "Custom "+sample.kind+" from the function.", sample.labelNames, nil),
+			series:     map[string]*series{},
+		}
+		s.families[name] = f
+	}
+
+	// All of the series in a family must have the same kind and labels,
+	// or none of them could be scraped.
+	if f.kind != sample.kind || strings.Join(f.labelNames, ",") != strings.Join(sample.labelNames, ",") {
+		s.dropped("conflict")
+		return
+	}
+
+	key := strings.Join(sample.labelValues, "\xff")
+	ser, ok := f.series[key]
+	if !ok {
+		if s.series >= s.opts.MaxSeries {
+			s.dropped("limit")
+			return
+		}
+
+		ser = &series{labelValues: sample.labelValues}
+		if f.kind == kindTimer {
+			ser.buckets = make([]float64, len(s.opts.Buckets))
+		}
+		f.series[key] = ser
+
+		s.series++
+		if s.metrics != nil {
+			s.metrics.Series.Set(float64(s.series))
+		}
+	}
+
+	switch f.kind {
+	case kindCounter:
+		ser.value += sample.value / sample.rate
+	case kindGauge:
+		if sample.relative {
+			ser.value += sample.value
+		} else {
+			ser.value = sample.value
+		}
+	case kindTimer:
+		weight := 1 / sample.rate
+		ser.count += weight
+		ser.sum += sample.value * weight
+		for i, upper := range s.opts.Buckets {
+			if sample.value <= upper {
+				ser.buckets[i] += weight
+			}
+		}
+	}
+
+	if s.metrics != nil {
+		s.metrics.Samples.Inc()
+	}
+}
+
+// clashes returns true when a new family would expose a series with the
+// same name as one of another family, such as a counter "render_count"
+// and a timer "render", which would fail the whole scrape.
+func (s *Server) clashes(name, kind string) bool {
+	for existing, f := range s.families {
+		for _, suffix := range timerSuffixes {
+			if f.kind == kindTimer && name == existing+suffix {
+				return true
+			}
+			if kind == kindTimer && existing == name+suffix {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// withoutReserved drops tags which can't be used as labels.
+func (s *Server) withoutReserved(in sample) sample {
+	out := in
+	out.labelNames = nil
+	out.labelValues = nil
+
+	for i, name := range in.labelNames {
+		if strings.HasPrefix(name, "__") || s.reserved(name) {
+			continue
+		}
+		out.labelNames = append(out.labelNames, name)
+		out.labelValues = append(out.labelValues, in.labelValues[i])
+	}
+
+	return out
+}
+
+func (s *Server) reserved(name string) bool {
+	for _, reserved := range s.opts.ReservedLabels {
+		if name == reserved {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Server) dropped(reason string) {
+	if s.metrics != nil {
+		s.metrics.Dropped.WithLabelValues(reason).Inc()
+	}
+}
+
+// Describe sends no descriptors, as the metrics are only known once the
+// function sends them.
+func (s *Server) Describe(chan<- *prometheus.Desc) {
+}
+
+// Collect sends the current value of each series. A series which can't
+// be exposed is skipped, rather than failing the scrape.
+func (s *Server) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, f := range s.families {
+		for _, ser := range f.series {
+			var metric prometheus.Metric
+			var err error
+
+			switch f.kind {
+			case kindCounter:
+				metric, err = prometheus.NewConstMetric(f.desc, prometheus.CounterValue, ser.value, ser.labelValues...)
+			case kindGauge:
+				metric, err = prometheus.NewConstMetric(f.desc, prometheus.GaugeValue, ser.value, ser.labelValues...)
+			case kindTimer:
+				buckets := make(map[float64]uint64, len(s.opts.Buckets))
+				for i, upper := range s.opts.Buckets {
+					buckets[upper] = uint64(math.Round(ser.buckets[i]))
+				}
+				metric, err = prometheus.NewConstHistogram(f.desc, uint64(math.Round(ser.count)), ser.sum, buckets, ser.labelValues...)
+			}
+
+			if err != nil {
+				continue
+			}
+			ch <- metric
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright (c) OpenFaaS Author(s) 2021. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package statsd
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openfaas/of-watchdog/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestServer_Aggregates(t *testing.T) {
+	s := NewServer(Options{Prefix: "custom", MaxSeries: 10, Buckets: []float64{0.1, 1}}, nil)
+
+	for _, line := range []string{
+		"orders:1|c|#region:eu",
+		"orders:2|c|@0.5|#region:eu",
+		"orders:1|c|#region:us",
+		"queue:10|g",
+		"queue:-3|g",
+		"render:50|ms",
+		"render:500|ms",
+	} {
+		s.Add(line)
+	}
+
+	want := `
+# HELP custom_orders Custom counter from the function.
+# TYPE custom_orders counter
+custom_orders{region="eu"} 5
+custom_orders{region="us"} 1
+# HELP custom_queue Custom gauge from the function.
+# TYPE custom_queue gauge
+custom_queue 7
+# HELP custom_render Custom timer from the function.
+# TYPE custom_render histogram
+custom_render_bucket{le="0.1"} 1
+custom_render_bucket{le="1"} 2
+custom_render_bucket{le="+Inf"} 2
+custom_render_sum 0.55
+custom_render_count 2
+`
+	if err := testutil.CollectAndCompare(s, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestServer_DropsOverSeriesLimitAndConflicts(t *testing.T) {
+	m := metrics.NewStatsD(prometheus.NewRegistry())
+	s := NewServer(Options{Prefix: "custom", MaxSeries: 2, ReservedLabels: []string{"function_name"}}, m)
+
+	s.Add("orders:1|c|#region:eu")
+	s.Add("orders:1|g|#region:eu")
+	s.Add("orders:1|c|#tier:free")
+	s.Add("orders:1|c|#region:us")
+	s.Add("orders:1|c|#region:apac")
+	s.Add("queue:1|g")
+	s.Add("queue:1|g|#function_name:other")
+	s.Add("not a metric")
+
+	if got := testutil.ToFloat64(m.Dropped.WithLabelValues("conflict")); got != 2 {
+		t.Errorf("want 2 conflicting samples dropped, got: %f", got)
+	}
+	if got := testutil.ToFloat64(m.Dropped.WithLabelValues("limit")); got != 3 {
+		t.Errorf("want 3 samples over the series limit dropped, got: %f", got)
+	}
+	if got := testutil.ToFloat64(m.Dropped.WithLabelValues("invalid")); got != 1 {
+		t.Errorf("want 1 invalid sample dropped, got: %f", got)
+	}
+	if got := testutil.ToFloat64(m.Series); got != 2 {
+		t.Errorf("want 2 series, got: %f", got)
+	}
+	if got := testutil.CollectAndCount(s); got != 2 {
+		t.Errorf("want 2 series collected, got: %d", got)
+	}
+}
+
+func TestServer_DropsInvalidUTF8(t *testing.T) {
+	m := metrics.NewStatsD(prometheus.NewRegistry())
+	s := NewServer(Options{Prefix: "custom", MaxSeries: 10}, m)
+
+	s.Add("orders:1|c|#region:\xff")
+	s.Add("orders:1|c|#region:eu")
+
+	if got := testutil.ToFloat64(m.Dropped.WithLabelValues("invalid")); got != 1 {
+		t.Errorf("want 1 invalid sample dropped, got: %f", got)
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(s)
+	if _, err := reg.Gather(); err != nil {
+		t.Errorf("want the scrape to succeed, got: %s", err)
+	}
+}
+
+func TestServer_DropsClashingNames(t *testing.T) {
+	m := metrics.NewStatsD(prometheus.NewRegistry())
+	s := NewServer(Options{Prefix: "custom", MaxSeries: 10}, m)
+
+	s.Add("a:10|ms")
+	s.Add("a_count:1|c")
+	s.Add("b_sum:1|g")
+	s.Add("b:10|ms")
+
+	if got := testutil.ToFloat64(m.Dropped.WithLabelValues("conflict")); got != 2 {
+		t.Errorf("want 2 clashing samples dropped, got: %f", got)
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(s)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("want the scrape to succeed, got: %s", err)
+	}
+	if len(families) != 2 {
+		t.Errorf("want custom_a and custom_b_sum, got %d families", len(families))
+	}
+}
+
+func TestServer_ReceivesUDP(t *testing.T) {
+	s := NewServer(Options{Prefix: "custom", MaxSeries: 10}, nil)
+	if err := s.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	conn, err := net.Dial("udp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("orders:1|c\norders:2|c\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `
+# HELP custom_orders Custom counter from the function.
+# TYPE custom_orders counter
+custom_orders 3
+`
+	for i := 0; i < 50; i++ {
+		if err = testutil.CollectAndCompare(s, strings.NewReader(want)); err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error(err)
+}